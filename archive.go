@@ -4,7 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
 	"strings"
 	"time"
@@ -12,23 +14,62 @@ import (
 	"compress/gzip"
 )
 
+// ErrPackageNotFound is returned when a request refers to a package that
+// is not present in a distribution
+var ErrPackageNotFound = errors.New("package not found in distribution")
+
+// ErrReleaseNotFound is returned when a request refers to a release that
+// is not in the history of a distribution
+var ErrReleaseNotFound = errors.New("release not found in distribution history")
+
+// NotAllowedError is returned when a package would be added to a
+// component, or for an architecture, that a distribution does not allow
+type NotAllowedError struct {
+	Reason string
+}
+
+func (e NotAllowedError) Error() string {
+	return e.Reason
+}
+
+// ErrSnapshotExists is returned when attempting to change an existing snapshot
+var ErrSnapshotExists = errors.New("snapshot already exists")
+
 // Archiver describes an interface for maintaining and generating
 // the on disk repository
 type Archiver interface {
 	PublicDir() string
+	StoreHandler() http.Handler
 	Dists() map[string]StoreID
 	GetDist(name string) (*Release, error)
 	SetDist(name string, newrel StoreID) error
 	ReifyRelease(id StoreID) (err error)
 	DeleteDist(name string) error
 	AddUpload(session *UploadSession) error
+	RemovePackage(dist string, source string, version DebVersion, arch string, who string) error
+	PruneDist(name string) error
+	PruneIndex(indexid StoreID, cfg *ReleaseConfig) (StoreID, []ReleaseLogAction, error)
+	RepairDist(name string) error
+	RefreshDist(name string) error
+	PromotePackage(from string, to string, source string, version DebVersion) error
+	RollbackDist(name string, id StoreID, who string) error
+	ForkDist(name string, from string, id StoreID) error
+	DistHasRelease(name string, id StoreID) (bool, error)
+	PackageHistory(dist string, source string, version DebVersion) (*PackageHistory, error)
+	Snapshots() map[string]StoreID
+	GetSnapshot(tag string) (*Release, error)
+	SetSnapshot(tag string, id StoreID) error
+	DeleteSnapshot(tag string) error
 	ArchiveStorer
 }
 
 // An Archiver that uses a version historied blob store
 type archiveStoreArchive struct {
-	base          *string // The base directory of the repository
-	ArchiveStorer         // The blob store to use
+	base          *string       // The base directory of the repository
+	publishGrace  time.Duration // How long to keep replaced generations of a dist
+	reify         bool          // Publish releases to the base directory
+	storeFS       *storeFileSystem
+	ArchiveStorer // The blob store to use
 }
 
 // NewAptBlobArchive creates a new Archiver that uses a version
@@ -37,14 +78,21 @@ func NewAptBlobArchive(
 	storeDir *string,
 	tmpDir *string,
 	publicDir *string,
+	publishGrace time.Duration,
+	reify bool,
 	defConfig ReleaseConfig,
 ) Archiver {
 	archivestore := NewArchiveBlobStore(*storeDir, *tmpDir, defConfig)
 
-	return &archiveStoreArchive{
+	a := &archiveStoreArchive{
 		ArchiveStorer: archivestore,
 		base:          publicDir,
+		publishGrace:  publishGrace,
+		reify:         reify,
 	}
+	a.storeFS = newStoreFileSystem(a)
+
+	return a
 }
 
 func (a *archiveStoreArchive) Dists() map[string]StoreID {
@@ -97,34 +145,154 @@ func (a *archiveStoreArchive) DeleteDist(name string) error {
 		return fmt.Errorf("Distribution note deleted, %v", err.Error())
 	}
 
+	os.RemoveAll(*a.base + "/pool/" + name)
+	os.RemoveAll(generationsDir(*a.base, name))
 	return os.RemoveAll(*a.base + "/dists/" + name)
 }
 
+// Snapshots returns the release ids of all the snapshots in the archive
+func (a *archiveStoreArchive) Snapshots() map[string]StoreID {
+	tags := a.ReleaseTags()
+	snaps := make(map[string]StoreID, 0)
+	for tag := range tags {
+		if !strings.HasPrefix(tag, "tags/") {
+			continue
+		}
+		tagSuffix := strings.TrimPrefix(tag, "tags/")
+		if strings.Index(tagSuffix, "/") != -1 {
+			continue
+		}
+		snaps[tagSuffix] = tags[tag]
+	}
+	return snaps
+}
+
+// GetSnapshot returns the release pinned by a snapshot
+func (a *archiveStoreArchive) GetSnapshot(tag string) (*Release, error) {
+	if strings.Index(tag, "/") != -1 {
+		return nil, errors.New("Snapshot name cannot include /")
+	}
+
+	releaseID, err := a.GetReleaseTag("tags/" + tag)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.GetRelease(releaseID)
+}
+
+// SetSnapshot pins a release under the given snapshot name, and publishes
+// it under the snapshots directory. Snapshots cannot be changed once
+// created, so cannot be refreshed. If the Release files of the release
+// expire, the snapshot pins a child of the release with the same content,
+// whose Release files have no Valid-Until.
+func (a *archiveStoreArchive) SetSnapshot(tag string, id StoreID) error {
+	if strings.Index(tag, "/") != -1 {
+		return errors.New("Snapshot name cannot include /")
+	}
+
+	if _, ok := a.Snapshots()[tag]; ok {
+		return ErrSnapshotExists
+	}
+
+	rel, err := a.GetRelease(id)
+	if err != nil {
+		return fmt.Errorf("Retrieving release failed, %v", err)
+	}
+
+	if rel.Config().ValidDuration() != 0 {
+		cfg := *rel.Config()
+		cfg.ValidFor = ""
+		cfgid, err := a.AddReleaseConfig(cfg)
+		if err != nil {
+			return fmt.Errorf("Storing snapshot config failed, %v", err)
+		}
+
+		actions := []ReleaseLogAction{{
+			Type:        ActionSNAPSHOT,
+			Description: fmt.Sprintf("Release files of %v regenerated without Valid-Until for snapshot %v", id.String(), tag),
+		}}
+		id, err = newChildRelease(a, rel, rel.IndexID, cfgid, actions)
+		if err != nil {
+			return fmt.Errorf("Creating snapshot release failed, %v", err)
+		}
+	}
+
+	err = a.SetReleaseTag("tags/"+tag, id)
+	if err != nil {
+		return fmt.Errorf("Setting snapshot ref failed, %v", err)
+	}
+
+	err = a.reifyRelease(a.snapshotDir(tag), id, true)
+	if err != nil {
+		a.DeleteReleaseTag("tags/" + tag)
+		return fmt.Errorf("Publishing snapshot failed, %v", err)
+	}
+
+	return nil
+}
+
+// DeleteSnapshot removes a snapshot, and its published files
+func (a *archiveStoreArchive) DeleteSnapshot(tag string) error {
+	if strings.Index(tag, "/") != -1 {
+		return errors.New("Snapshot name cannot include /")
+	}
+
+	err := a.DeleteReleaseTag("tags/" + tag)
+	if err != nil {
+		return fmt.Errorf("Snapshot not deleted, %v", err.Error())
+	}
+
+	return os.RemoveAll(a.snapshotDir(tag))
+}
+
+func (a *archiveStoreArchive) snapshotDir(tag string) string {
+	return *a.base + "/snapshots/" + tag
+}
+
+// ReifyRelease publishes the given release to the public directory
 func (a *archiveStoreArchive) ReifyRelease(id StoreID) (err error) {
+	return a.reifyRelease(*a.base, id, false)
+}
+
+// RepairDist republishes the current release of a distribution,
+// rebuilding its whole pool rather than just applying the changes
+// from the previous release
+func (a *archiveStoreArchive) RepairDist(name string) error {
+	head, ok := a.Dists()[name]
+	if !ok {
+		return fmt.Errorf("Distribution %v does not exist", name)
+	}
+
+	return a.reifyRelease(*a.base, head, true)
+}
+
+// reifyRelease publishes the release, and its pool, to the dists and pool
+// directories of base. The release is built in a new generation directory
+// which replaces the published one once it is complete. Only the changes
+// from the previously published release are applied to the pool, unless
+// fullPool is set. Nothing is published if the archive is being served
+// directly from the store
+func (a *archiveStoreArchive) reifyRelease(base string, id StoreID, fullPool bool) (err error) {
+	if !a.reify {
+		return nil
+	}
+
 	release, err := a.GetRelease(id)
 	if err != nil {
 		return err
 	}
 
-	distBase := *a.base + "/dists/" + release.CodeName
-	distAlias := *a.base + "/dists/" + release.Suite
-
-	clearTime := time.Now()
-	clearDist := func() {
-		os.Remove(distAlias)
-		os.RemoveAll(distBase)
-	}
-	clearDist()
-	clearDuration := time.Since(clearTime)
-	log.Println("Cleared old distribution in ", clearDuration)
+	reifyTime := time.Now()
+	gen := newGeneration(base, release.CodeName, reifyTime)
+	distBase := gen + "/dists/" + release.CodeName
 
 	defer func() {
 		if err != nil {
-			clearDist()
+			os.RemoveAll(gen)
 		}
 	}()
 
-	reifyTime := time.Now()
 	fileCount := 0
 	log.Printf("Reifying release %v", release.CodeName)
 
@@ -141,6 +309,35 @@ func (a *archiveStoreArchive) ReifyRelease(id StoreID) (err error) {
 			return err
 		}
 
+		for _, c := range component.SourcesCompressed {
+			err = a.Link(c.ID, sourcesBase+"/Sources."+c.Compression)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(component.SourcesRelease) != 0 {
+			err = a.Link(component.SourcesRelease, sourcesBase+"/Release")
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(component.TranslationsGz) != 0 {
+			i18nBase := componentBase + "/i18n"
+			err = a.Link(component.TranslationsGz, i18nBase+"/Translation-en.gz")
+			if err != nil {
+				return err
+			}
+
+			for _, c := range component.TranslationsCompressed {
+				err = a.Link(c.ID, i18nBase+"/Translation-en."+c.Compression)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
 		// Reify the uncompressed sources file
 		gzreader, err := os.Open(sourcesBase + "/Sources.gz")
 		defer gzreader.Close()
@@ -169,6 +366,27 @@ func (a *archiveStoreArchive) ReifyRelease(id StoreID) (err error) {
 				return err
 			}
 
+			for _, c := range arch.PackagesCompressed {
+				err = a.Link(c.ID, archBase+"/Packages."+c.Compression)
+				if err != nil {
+					return err
+				}
+			}
+
+			if len(arch.ReleaseFile) != 0 {
+				err = a.Link(arch.ReleaseFile, archBase+"/Release")
+				if err != nil {
+					return err
+				}
+			}
+
+			if len(arch.ContentsGz) != 0 {
+				err = a.Link(arch.ContentsGz, componentBase+"/Contents-"+arch.Name+".gz")
+				if err != nil {
+					return err
+				}
+			}
+
 			// Reify the uncompressed packages file
 			gzreader, err := os.Open(archBase + "/Packages.gz")
 			defer gzreader.Close()
@@ -190,6 +408,11 @@ func (a *archiveStoreArchive) ReifyRelease(id StoreID) (err error) {
 
 	}
 
+	err = a.publishByHashGenerations(distBase, base+"/dists/"+release.CodeName, release)
+	if err != nil {
+		return err
+	}
+
 	err = a.Link(release.Release, distBase+"/Release")
 	if err != nil {
 		return err
@@ -215,71 +438,32 @@ func (a *archiveStoreArchive) ReifyRelease(id StoreID) (err error) {
 		}
 	}
 
-	err = a.updatePool(release)
+	// New pool files are linked in before the release is published
+	pool, err := a.updatePool(base, release, fullPool)
 	if err != nil {
 		return err
 	}
 
-	reifyDuration := time.Since(reifyTime)
-	log.Printf("Reified %v files in %v ", fileCount, reifyDuration)
-
-	return
-}
+	prevGen := currentGeneration(base, release.CodeName)
 
-func (a *archiveStoreArchive) updatePool(release *Release) error {
-	index, err := a.OpenReleaseIndex(release.IndexID)
-	defer index.Close()
+	err = ioutil.WriteFile(gen+"/release", []byte(id.String()+"\n"), 0666)
 	if err != nil {
 		return err
 	}
 
-	poolBase := a.PublicDir() + "/pool/" + release.CodeName
-	log.Printf("Clearing pool %v ", poolBase)
-	os.RemoveAll(poolBase)
-
-	for {
-		e, err := index.NextEntry()
-		if err != nil {
-			if err != io.EOF {
-				return err
-			}
-			break
-		}
-
-		srcName := e.SourceItem.Name
-		srcVersion := e.SourceItem.Version.String()
-		poolpath := fmt.Sprintf("%s/%s%s/%s/",
-			a.PublicDir(),
-			release.PoolFilePath(srcName),
-			srcName,
-			srcVersion,
-		)
+	err = publishGeneration(base, release.CodeName, gen)
+	if err != nil {
+		return err
+	}
 
-		err = a.Link(e.ChangesID, poolpath+fmt.Sprintf("%s_%s.changes", srcName, srcVersion))
-		if err != nil {
-			return err
-		}
+	// Removed pool files are still referenced by the previous generation
+	retirePoolFiles(base, prevGen, pool.removed)
+	removeOldGenerations(base, release.CodeName, gen, a.publishGrace, time.Now(), pool.files)
 
-		for _, s := range e.SourceItem.Files {
-			filename := s.Name
-			path := poolpath + filename
-			err = a.Link(s.StoreID, path)
-			if err != nil {
-				return err
-			}
-		}
+	reifyDuration := time.Since(reifyTime)
+	log.Printf("Reified %v files in %v ", fileCount, reifyDuration)
 
-		for _, b := range e.BinaryItems {
-			filename := b.Files[0].Name
-			path := poolpath + filename
-			err = a.Link(b.Files[0].StoreID, path)
-			if err != nil {
-				return err
-			}
-		}
-	}
-	log.Printf("Pool rebuild complete")
-	return nil
+	return
 }
 
 // Return the raw path to the base directory, used for directly
@@ -288,6 +472,13 @@ func (a *archiveStoreArchive) PublicDir() string {
 	return *a.base
 }
 
+// StoreHandler returns a handler that serves the distributions and
+// snapshots directly from the store, as they would be published to the
+// base directory
+func (a *archiveStoreArchive) StoreHandler() http.Handler {
+	return a.storeFS
+}
+
 func (a *archiveStoreArchive) AddUpload(session *UploadSession) error {
 
 	entry, err := NewReleaseIndexEntry(session)
@@ -318,6 +509,384 @@ func (a *archiveStoreArchive) AddUpload(session *UploadSession) error {
 		return fmt.Errorf("Creating new index failed, %v", err)
 	}
 
+	return a.commitIndex(branchName, head, newidx, actions)
+}
+
+// RemovePackage removes a source package, and all its binaries, from the
+// named distribution. If arch is given, only the items for that
+// architecture are removed.
+func (a *archiveStoreArchive) RemovePackage(dist string, source string, version DebVersion, arch string, who string) error {
+	head, ok := a.Dists()[dist]
+	if !ok {
+		return fmt.Errorf("Distribution %v does not exist", dist)
+	}
+
+	newidx, actions, err := a.removeEntryFromRelease(head, source, version, arch, who)
+	if err != nil {
+		return fmt.Errorf("Creating new index failed, %v", err)
+	}
+
+	if len(actions) == 0 {
+		return ErrPackageNotFound
+	}
+
+	return a.commitIndex(dist, head, newidx, actions)
+}
+
+// PruneDist applies the prune rules of a distribution to its whole
+// index. Normally pruning only happens as packages are uploaded
+func (a *archiveStoreArchive) PruneDist(name string) error {
+	head, ok := a.Dists()[name]
+	if !ok {
+		return fmt.Errorf("Distribution %v does not exist", name)
+	}
+
+	newidx, actions, err := a.pruneRelease(head)
+	if err != nil {
+		return fmt.Errorf("Creating new index failed, %v", err)
+	}
+
+	return a.commitIndex(name, head, newidx, actions)
+}
+
+// RefreshDist regenerates and re-signs the release files of a
+// distribution, without changing its content, so that a new
+// Valid-Until date is set
+func (a *archiveStoreArchive) RefreshDist(name string) error {
+	head, err := a.GetDist(name)
+	if err != nil {
+		return err
+	}
+
+	desc := "Release files refreshed"
+	if validUntil := head.ValidUntil(); !validUntil.IsZero() {
+		desc += ", previously valid until " + DebFormatTime(validUntil.UTC())
+	}
+	actions := []ReleaseLogAction{{Type: ActionREFRESH, Description: desc}}
+
+	newhead, err := NewRelease(a, head.id, head.IndexID, actions)
+	if err != nil {
+		return fmt.Errorf("Creating refreshed commit failed, %v", err)
+	}
+
+	if err = a.SetDist(name, newhead); err != nil {
+		return fmt.Errorf("Setting dist ref failed, %v", err)
+	}
+	log.Printf("Branch %v refreshed to %v", name, StoreID(newhead).String())
+
+	if err = a.ReifyRelease(newhead); err != nil {
+		return fmt.Errorf("Repopulating the archive directory failed,, %v", err)
+	}
+
+	a.GarbageCollect()
+	return nil
+}
+
+// PromotePackage copies a source package, and all its binaries, from one
+// distribution to another. The files are not re-uploaded, the existing
+// items in the store are merged into the target distribution
+func (a *archiveStoreArchive) PromotePackage(from string, to string, source string, version DebVersion) error {
+	heads := a.Dists()
+	fromHead, ok := heads[from]
+	if !ok {
+		return fmt.Errorf("Distribution %v does not exist", from)
+	}
+	toHead, ok := heads[to]
+	if !ok {
+		return fmt.Errorf("Distribution %v does not exist", to)
+	}
+
+	fromRel, err := a.GetRelease(fromHead)
+	if err != nil {
+		return fmt.Errorf("Retrieving source release failed, %v", err)
+	}
+
+	entry, err := a.findEntry(fromRel.IndexID, source, version)
+	if err != nil {
+		return err
+	}
+
+	toRel, err := a.GetRelease(toHead)
+	if err != nil {
+		return fmt.Errorf("Retrieving target release failed, %v", err)
+	}
+
+	err = checkEntryAllowed(toRel.Config(), entry)
+	if err != nil {
+		return err
+	}
+
+	newidx, actions, err := a.mergeEntryIntoRelease(toHead, entry)
+	if err != nil {
+		return fmt.Errorf("Creating new index failed, %v", err)
+	}
+
+	for i := range actions {
+		if actions[i].Type == ActionADD {
+			actions[i].Description = fmt.Sprintf("%s promoted from %s@%s",
+				actions[i].Description,
+				from,
+				fromHead.String())
+		}
+	}
+
+	return a.commitIndex(to, toHead, newidx, actions)
+}
+
+// checkEntryAllowed returns a NotAllowedError if any item of the entry is
+// in a component, or for an architecture, that the configuration does not
+// allow packages to be uploaded to
+func checkEntryAllowed(cfg *ReleaseConfig, entry *ReleaseIndexEntry) error {
+	componentAllowed := func(item ReleaseIndexEntryItem) error {
+		comp := item.Component
+		if comp == "" {
+			comp = "main"
+		}
+		if !cfg.AllowedComponent(comp) {
+			return NotAllowedError{fmt.Sprintf("component %v for %v is not allowed in this distribution", comp, item.Name)}
+		}
+		return nil
+	}
+
+	if err := componentAllowed(entry.SourceItem); err != nil {
+		return err
+	}
+
+	for _, item := range entry.BinaryItems {
+		if err := componentAllowed(item); err != nil {
+			return err
+		}
+		if !cfg.AllowedArchitecture(item.Architecture) {
+			return NotAllowedError{fmt.Sprintf("architecture %v for %v is not allowed in this distribution", item.Architecture, item.Name)}
+		}
+	}
+
+	return nil
+}
+
+// RollbackDist creates a new release for the named distribution with the
+// index and configuration of an earlier release from its history. The
+// history is kept linear, the rollback is recorded as a new release.
+func (a *archiveStoreArchive) RollbackDist(name string, id StoreID, who string) error {
+	head, ok := a.Dists()[name]
+	if !ok {
+		return fmt.Errorf("Distribution %v does not exist", name)
+	}
+
+	if id.String() == head.String() {
+		return errors.New("Cannot roll back to the current release")
+	}
+
+	found, err := a.releaseInHistory(head, id)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrReleaseNotFound
+	}
+
+	target, err := a.GetRelease(id)
+	if err != nil {
+		return fmt.Errorf("Retrieving target release failed, %v", err)
+	}
+
+	// The assets of trimmed releases may have been garbage collected
+	index, err := a.OpenReleaseIndex(target.IndexID)
+	if err != nil {
+		return fmt.Errorf("Release %v is no longer available, %v", id.String(), err)
+	}
+	index.Close()
+
+	parent, err := a.GetRelease(head)
+	if err != nil {
+		return fmt.Errorf("Retrieving current release failed, %v", err)
+	}
+
+	actions := []ReleaseLogAction{
+		ReleaseLogAction{
+			Type:        ActionROLLBACK,
+			Description: fmt.Sprintf("Rolled back to release %v (version %v) by %v", id.String(), target.Version, who),
+		},
+	}
+
+	newhead, err := newChildRelease(a, parent, target.IndexID, target.ConfigID, actions)
+	if err != nil {
+		return fmt.Errorf("Creating rollback commit failed, %v", err)
+	}
+
+	if err = a.SetDist(name, newhead); err != nil {
+		return fmt.Errorf("Setting dist ref failed, %v", err)
+	}
+	log.Printf("Branch %v rolled back to %v as %v", name, id.String(), newhead.String())
+
+	if err = a.ReifyRelease(newhead); err != nil {
+		return fmt.Errorf("Repopulating the archive directory failed,, %v", err)
+	}
+
+	a.GarbageCollect()
+	return nil
+}
+
+// ForkDist creates a new distribution from a release of an existing
+// distribution. If id is nil the current release of the existing
+// distribution is used. The new distribution shares the index and
+// configuration of the release, but has its own history.
+func (a *archiveStoreArchive) ForkDist(name string, from string, id StoreID) error {
+	heads := a.Dists()
+	if _, ok := heads[name]; ok {
+		return fmt.Errorf("Distribution %v already exists", name)
+	}
+
+	fromHead, ok := heads[from]
+	if !ok {
+		return fmt.Errorf("Distribution %v does not exist", from)
+	}
+
+	if id == nil {
+		id = fromHead
+	} else {
+		found, err := a.releaseInHistory(fromHead, id)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return ErrReleaseNotFound
+		}
+	}
+
+	src, err := a.GetRelease(id)
+	if err != nil {
+		return fmt.Errorf("Retrieving source release failed, %v", err)
+	}
+
+	rootid, err := a.GetReleaseRoot(Release{
+		Suite:    name,
+		CodeName: name,
+		Version:  "0",
+	})
+	if err != nil {
+		return fmt.Errorf("Get release root failed, %v", err)
+	}
+
+	root, err := a.GetRelease(rootid)
+	if err != nil {
+		return fmt.Errorf("Get release root failed, %v", err)
+	}
+
+	actions := []ReleaseLogAction{
+		ReleaseLogAction{
+			Type:        ActionFORK,
+			Description: fmt.Sprintf("Forked from %v@%v", from, id.String()),
+		},
+	}
+
+	newhead, err := newChildRelease(a, root, src.IndexID, src.ConfigID, actions)
+	if err != nil {
+		return fmt.Errorf("Creating fork commit failed, %v", err)
+	}
+
+	if err = a.SetDist(name, newhead); err != nil {
+		return fmt.Errorf("Setting dist ref failed, %v", err)
+	}
+	log.Printf("Branch %v forked from %v@%v", name, from, id.String())
+
+	if err = a.ReifyRelease(newhead); err != nil {
+		return fmt.Errorf("Repopulating the archive directory failed,, %v", err)
+	}
+
+	return nil
+}
+
+// PackageHistory describes when a package was present in a distribution
+type PackageHistory struct {
+	Entry          *ReleaseIndexEntry
+	FirstRelease   StoreID // The first release the package appeared in
+	RemovedRelease StoreID `json:",omitempty"` // The release the package was removed in
+}
+
+// PackageHistory walks the history of a distribution to find the most
+// recent entry for a package, and the releases in which it appeared and,
+// if it is no longer present, was removed
+func (a *archiveStoreArchive) PackageHistory(dist string, source string, version DebVersion) (*PackageHistory, error) {
+	head, ok := a.Dists()[dist]
+	if !ok {
+		return nil, fmt.Errorf("Distribution %v does not exist", dist)
+	}
+
+	var res *PackageHistory
+	var child StoreID
+
+	for curr := head; curr.String() != a.EmptyFileID().String(); {
+		rel, err := a.GetRelease(curr)
+		if err != nil {
+			return nil, fmt.Errorf("Walking release history failed, %v", err)
+		}
+
+		entry, err := a.findEntry(rel.IndexID, source, version)
+		switch {
+		case err == nil:
+			if res == nil {
+				res = &PackageHistory{
+					Entry:          entry,
+					RemovedRelease: child,
+				}
+			}
+			res.FirstRelease = curr
+		case err == ErrPackageNotFound:
+			if res != nil {
+				// We've found the start of the most recent period
+				// the package was present for
+				return res, nil
+			}
+		default:
+			// The index for trimmed releases may have been garbage
+			// collected, we can't see any further back
+			if res != nil {
+				return res, nil
+			}
+			return nil, ErrPackageNotFound
+		}
+
+		child = curr
+		curr = rel.ParentID
+	}
+
+	if res == nil {
+		return nil, ErrPackageNotFound
+	}
+
+	return res, nil
+}
+
+// DistHasRelease returns true if the release id is the current release of
+// the named distribution, or one of its ancestors
+func (a *archiveStoreArchive) DistHasRelease(name string, id StoreID) (bool, error) {
+	head, ok := a.Dists()[name]
+	if !ok {
+		return false, fmt.Errorf("Distribution %v does not exist", name)
+	}
+	return a.releaseInHistory(head, id)
+}
+
+// releaseInHistory returns true if the release id is head, or one of its
+// ancestors
+func (a *archiveStoreArchive) releaseInHistory(head StoreID, id StoreID) (bool, error) {
+	for curr := head; curr.String() != a.EmptyFileID().String(); {
+		if curr.String() == id.String() {
+			return true, nil
+		}
+		rel, err := a.GetRelease(curr)
+		if err != nil {
+			return false, fmt.Errorf("Walking release history failed, %v", err)
+		}
+		curr = rel.ParentID
+	}
+	return false, nil
+}
+
+// commitIndex creates a new release from the given index, and actions, and
+// makes it the current head of the named distribution
+func (a *archiveStoreArchive) commitIndex(branchName string, head StoreID, newidx StoreID, actions []ReleaseLogAction) error {
 	realchange := false
 	for _, item := range actions {
 		switch item.Type {
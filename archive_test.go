@@ -0,0 +1,474 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRollbackDist(t *testing.T) {
+	a, clean, err := makeTestArchive(t)
+	if err != nil {
+		return
+	}
+	defer clean()
+
+	rootid, err := a.GetReleaseRoot(Release{CodeName: "test", Suite: "test", Version: "0"})
+	if err != nil {
+		t.Fatalf("creating release root failed, %v", err)
+	}
+	emptyidx, _ := a.EmptyReleaseIndex()
+	firstid, err := NewRelease(a, rootid, emptyidx, []ReleaseLogAction{})
+	if err != nil {
+		t.Fatalf("creating release failed, %v", err)
+	}
+
+	first, _ := a.GetRelease(firstid)
+	second := first.NewChild()
+	cfg := *second.Config()
+	cfg.AcceptLoneDebs = true
+	second.ConfigID, _ = a.AddReleaseConfig(cfg)
+	secondid, _ := a.AddRelease(second)
+	a.SetDist("test", secondid)
+
+	if found, err := a.DistHasRelease("test", firstid); err != nil || !found {
+		t.Errorf("release should be in the dist history, %v", err)
+	}
+	if found, _ := a.DistHasRelease("test", StoreID([]byte("01234567890123456789"))); found {
+		t.Errorf("unknown release should not be in the dist history")
+	}
+
+	err = a.RollbackDist("test", StoreID([]byte("01234567890123456789")), "tester")
+	if err != ErrReleaseNotFound {
+		t.Errorf("rollback to unknown release should fail, got %v", err)
+	}
+
+	err = a.RollbackDist("test", firstid, "tester")
+	if err != nil {
+		t.Fatalf("rollback failed, %v", err)
+	}
+
+	head, err := a.GetDist("test")
+	if err != nil {
+		t.Fatalf("retrieving dist failed, %v", err)
+	}
+
+	if head.ParentID.String() != secondid.String() {
+		t.Errorf("rollback should create a child of the current head")
+	}
+	if head.ConfigID.String() != first.ConfigID.String() {
+		t.Errorf("rollback should restore the old config")
+	}
+	if head.Config().AcceptLoneDebs {
+		t.Errorf("rollback returned the wrong configuration")
+	}
+	if len(head.Actions) != 1 || head.Actions[0].Type != ActionROLLBACK {
+		t.Errorf("rollback should be recorded in the log, got %v", head.Actions)
+	}
+}
+
+func TestSnapshots(t *testing.T) {
+	a, clean, err := makeTestArchive(t)
+	if err != nil {
+		return
+	}
+	defer clean()
+
+	rootid, err := a.GetReleaseRoot(Release{CodeName: "test", Suite: "test", Version: "0"})
+	if err != nil {
+		t.Fatalf("creating release root failed, %v", err)
+	}
+	emptyidx, _ := a.EmptyReleaseIndex()
+	relid, err := NewRelease(a, rootid, emptyidx, []ReleaseLogAction{})
+	if err != nil {
+		t.Fatalf("creating release failed, %v", err)
+	}
+	a.SetDist("test", relid)
+
+	err = a.SetSnapshot("snap1", relid)
+	if err != nil {
+		t.Fatalf("creating snapshot failed, %v", err)
+	}
+
+	if _, err = os.Stat(a.PublicDir() + "/snapshots/snap1/dists/test/Release"); err != nil {
+		t.Errorf("snapshot was not published, %v", err)
+	}
+
+	if err = a.SetSnapshot("snap1", relid); err != ErrSnapshotExists {
+		t.Errorf("snapshots should not be updatable, got %v", err)
+	}
+
+	if _, ok := a.Dists()["snap1"]; ok {
+		t.Errorf("snapshots should not be listed as distributions")
+	}
+
+	snap, err := a.GetSnapshot("snap1")
+	if err != nil || snap.CodeName != "test" {
+		t.Errorf("retrieving snapshot failed, %v", err)
+	}
+
+	if snap.id.String() != relid.String() {
+		t.Errorf("snapshot of a release that does not expire should pin the release itself")
+	}
+
+	if err = a.DeleteSnapshot("snap1"); err != nil {
+		t.Errorf("deleting snapshot failed, %v", err)
+	}
+	if _, ok := a.Snapshots()["snap1"]; ok {
+		t.Errorf("snapshot was not deleted")
+	}
+
+	// Snapshots cannot be refreshed, so their Release files do not expire
+	rel, _ := a.GetRelease(relid)
+	expiring := rel.NewChild()
+	cfg := *expiring.Config()
+	cfg.ValidFor = "7d"
+	expiring.ConfigID, _ = a.AddReleaseConfig(cfg)
+	expiring.config = nil
+	expiring.updateReleasefiles()
+	expiringid, _ := a.AddRelease(expiring)
+	expiring, _ = a.GetRelease(expiringid)
+	if expiring.ValidUntil().IsZero() {
+		t.Fatalf("release should expire")
+	}
+
+	err = a.SetSnapshot("snap2", expiringid)
+	if err != nil {
+		t.Fatalf("creating snapshot failed, %v", err)
+	}
+
+	snap, err = a.GetSnapshot("snap2")
+	if err != nil {
+		t.Fatalf("retrieving snapshot failed, %v", err)
+	}
+	if !snap.ValidUntil().IsZero() {
+		t.Errorf("snapshot Release files should not expire")
+	}
+	if snap.ParentID.String() != expiringid.String() || snap.IndexID.String() != expiring.IndexID.String() {
+		t.Errorf("snapshot should pin a child of the release with the same content")
+	}
+	if len(snap.Actions) != 1 || snap.Actions[0].Type != ActionSNAPSHOT {
+		t.Errorf("snapshot should be recorded in the log, got %v", snap.Actions)
+	}
+
+	release, err := ioutil.ReadFile(a.PublicDir() + "/snapshots/snap2/dists/test/Release")
+	if err != nil || strings.Contains(string(release), "Valid-Until") {
+		t.Errorf("published snapshot Release should not have a Valid-Until, %v", err)
+	}
+}
+
+func TestForkDist(t *testing.T) {
+	a, clean, err := makeTestArchive(t)
+	if err != nil {
+		return
+	}
+	defer clean()
+
+	relid := makeTestRelease(t, a, "test", nil)
+	rel, _ := a.GetRelease(relid)
+	cfg := *rel.Config()
+	cfg.AcceptLoneDebs = true
+	rel.ConfigID, _ = a.AddReleaseConfig(cfg)
+	relid, _ = a.AddRelease(rel)
+	a.SetDist("test", relid)
+
+	if err = a.ForkDist("test", "test", nil); err == nil {
+		t.Errorf("forking to an existing dist should fail")
+	}
+
+	err = a.ForkDist("forked", "test", nil)
+	if err != nil {
+		t.Fatalf("fork failed, %v", err)
+	}
+
+	fork, err := a.GetDist("forked")
+	if err != nil {
+		t.Fatalf("retrieving fork failed, %v", err)
+	}
+
+	if fork.CodeName != "forked" || fork.Suite != "forked" {
+		t.Errorf("fork has wrong names, %v %v", fork.CodeName, fork.Suite)
+	}
+	if fork.IndexID.String() != rel.IndexID.String() {
+		t.Errorf("fork should share the original index")
+	}
+	if !fork.Config().AcceptLoneDebs {
+		t.Errorf("fork should share the original config")
+	}
+	if len(fork.Actions) != 1 || fork.Actions[0].Type != ActionFORK {
+		t.Errorf("fork should be recorded in the log, got %v", fork.Actions)
+	}
+}
+
+func TestPackageHistory(t *testing.T) {
+	a, clean, err := makeTestArchive(t)
+	if err != nil {
+		return
+	}
+	defer clean()
+
+	// Build a history where pkga 1-1 is added in the second release
+	// and removed in the fourth
+	ids := []StoreID{makeTestRelease(t, a, "test", testRemoveInput[:1])}
+	indexes := [][]*ReleaseIndexEntry{testRemoveInput, testRemoveInput, testRemoveInput[:1]}
+	for _, entries := range indexes {
+		idx, _ := a.AddReleaseIndex()
+		for _, e := range entries {
+			idx.AddEntry(e)
+		}
+		idxid, _ := idx.Close()
+
+		rel, _ := a.GetRelease(ids[len(ids)-1])
+		rel = rel.NewChild()
+		rel.IndexID = idxid
+		relid, _ := a.AddRelease(rel)
+		ids = append(ids, relid)
+	}
+	a.SetDist("test", ids[len(ids)-1])
+
+	hist, err := a.PackageHistory("test", "pkga", MustParseDebVersion("1-1"))
+	if err != nil {
+		t.Fatalf("retrieving history failed, %v", err)
+	}
+	if hist.FirstRelease.String() != ids[1].String() {
+		t.Errorf("wrong first release, expected %v got %v", ids[1], hist.FirstRelease)
+	}
+	if hist.RemovedRelease.String() != ids[3].String() {
+		t.Errorf("wrong removed release, expected %v got %v", ids[3], hist.RemovedRelease)
+	}
+
+	hist, err = a.PackageHistory("test", "pkga", MustParseDebVersion("2-1"))
+	if err != nil {
+		t.Fatalf("retrieving history failed, %v", err)
+	}
+	if hist.FirstRelease.String() != ids[0].String() || len(hist.RemovedRelease) != 0 {
+		t.Errorf("wrong history for current package, %v", hist)
+	}
+
+	_, err = a.PackageHistory("test", "pkgb", MustParseDebVersion("1-1"))
+	if err != ErrPackageNotFound {
+		t.Errorf("unknown package should not be found, got %v", err)
+	}
+}
+
+func TestCheckValidFor(t *testing.T) {
+	tests := []struct {
+		validFor string
+		interval time.Duration
+		err      bool
+	}{
+		{"7d", time.Hour, false},
+		{"2h", time.Hour, false},
+		{"90m", time.Hour, true},
+		{"-1h", time.Hour, true},
+		{"0s", time.Hour, true},
+		{"1h", 0, false},
+	}
+
+	for _, tt := range tests {
+		err := CheckValidFor(tt.validFor, tt.interval)
+		if tt.err && err == nil {
+			t.Errorf("CheckValidFor(%v, %v) should have failed", tt.validFor, tt.interval)
+		}
+		if !tt.err && err != nil {
+			t.Errorf("CheckValidFor(%v, %v) failed, %v", tt.validFor, tt.interval, err)
+		}
+	}
+}
+
+func TestRefreshDist(t *testing.T) {
+	a, clean, err := makeTestArchive(t)
+	if err != nil {
+		return
+	}
+	defer clean()
+
+	rootid, err := a.GetReleaseRoot(Release{CodeName: "test", Suite: "test", Version: "0"})
+	if err != nil {
+		t.Fatalf("creating release root failed, %v", err)
+	}
+	emptyidx, _ := a.EmptyReleaseIndex()
+	firstid, err := NewRelease(a, rootid, emptyidx, []ReleaseLogAction{})
+	if err != nil {
+		t.Fatalf("creating release failed, %v", err)
+	}
+
+	first, _ := a.GetRelease(firstid)
+	second := first.NewChild()
+	cfg := *second.Config()
+	cfg.Origin = "Acme"
+	cfg.Label = "Acme Packages"
+	cfg.ValidFor = "7d"
+	cfg.NotAutomatic = true
+	cfg.ButAutomaticUpgrades = true
+	second.ConfigID, _ = a.AddReleaseConfig(cfg)
+	second.config = nil
+	second.Date = time.Now().Add(-5 * 24 * time.Hour)
+	second.updateReleasefiles()
+	secondid, _ := a.AddRelease(second)
+	a.SetDist("test", secondid)
+
+	second, _ = a.GetRelease(secondid)
+	if !releaseNeedsRefresh(second, time.Now()) {
+		t.Errorf("release with 2 days of 7 remaining should need refreshing")
+	}
+	if releaseNeedsRefresh(second, second.Date.Add(time.Hour)) {
+		t.Errorf("new release should not need refreshing")
+	}
+
+	err = a.RefreshDist("test")
+	if err != nil {
+		t.Fatalf("refresh failed, %v", err)
+	}
+
+	head, _ := a.GetDist("test")
+	if head.ParentID.String() != secondid.String() {
+		t.Errorf("refresh should create a child of the current head")
+	}
+	if head.IndexID.String() != second.IndexID.String() {
+		t.Errorf("refresh should not change the index")
+	}
+	if len(head.Actions) != 1 || head.Actions[0].Type != ActionREFRESH {
+		t.Errorf("refresh should be recorded in the log, got %v", head.Actions)
+	}
+	if releaseNeedsRefresh(head, time.Now()) {
+		t.Errorf("refreshed release should not need refreshing")
+	}
+
+	rdr, err := a.Open(head.Release)
+	if err != nil {
+		t.Fatalf("opening release file failed, %v", err)
+	}
+	defer rdr.Close()
+	ctrl, err := ParseDebianControl(rdr, nil)
+	if err != nil {
+		t.Fatalf("parsing release file failed, %v", err)
+	}
+
+	para := ctrl.Data[0]
+	for field, expected := range map[string]string{
+		"Origin":               "Acme",
+		"Label":                "Acme Packages",
+		"Valid-Until":          DebFormatTime(head.Date.Add(7 * 24 * time.Hour).UTC()),
+		"NotAutomatic":         "yes",
+		"ButAutomaticUpgrades": "yes",
+	} {
+		v, ok := para.GetValues(field)
+		if !ok {
+			t.Errorf("%v field missing", field)
+			continue
+		}
+		if *v[0] != expected {
+			t.Errorf("expected %v %q, got %q", field, expected, *v[0])
+		}
+	}
+	if _, ok := para.GetValues("Description"); ok {
+		t.Errorf("unexpected Description field")
+	}
+}
+
+func TestRefreshDistTrim(t *testing.T) {
+	a, clean, err := makeTestArchive(t)
+	if err != nil {
+		return
+	}
+	defer clean()
+
+	rootid, err := a.GetReleaseRoot(Release{CodeName: "test", Suite: "test", Version: "0"})
+	if err != nil {
+		t.Fatalf("creating release root failed, %v", err)
+	}
+	root, _ := a.GetRelease(rootid)
+	emptyidx, _ := a.EmptyReleaseIndex()
+
+	cfg := *root.Config()
+	cfg.AutoTrim = true
+	cfg.AutoTrimLength = 1
+	cfg.ValidFor = "7d"
+	cfgid, _ := a.AddReleaseConfig(cfg)
+	relid, err := newChildRelease(a, root, emptyidx, cfgid, []ReleaseLogAction{{Type: ActionCONFIGCHANGE}})
+	if err != nil {
+		t.Fatalf("creating release failed, %v", err)
+	}
+
+	var uploads []StoreID
+	for i := 0; i < 3; i++ {
+		relid, err = NewRelease(a, relid, emptyidx, []ReleaseLogAction{{Type: ActionADD}})
+		if err != nil {
+			t.Fatalf("creating release failed, %v", err)
+		}
+		uploads = append(uploads, relid)
+	}
+	a.SetDist("test", relid)
+
+	for i := 0; i < 5; i++ {
+		if err = a.RefreshDist("test"); err != nil {
+			t.Fatalf("refresh failed, %v", err)
+		}
+	}
+
+	head, _ := a.GetDist("test")
+	if !head.IsRefresh() {
+		t.Errorf("expected the head to be a refresh, got %v", head.Actions)
+	}
+
+	retained, err := retainedHistory(a, head)
+	if err != nil {
+		t.Fatalf("reading history failed, %v", err)
+	}
+	kept := map[string]bool{}
+	for _, rel := range retained {
+		kept[rel.id.String()] = true
+	}
+
+	// The length limit keeps the two most recent uploads, the refreshes
+	// are not counted
+	for i, id := range uploads {
+		if kept[id.String()] != (i > 0) {
+			t.Errorf("upload %v retained = %v, expected %v", i, kept[id.String()], i > 0)
+		}
+	}
+	if len(retained) != 7 {
+		t.Errorf("expected 5 refreshes and 2 uploads to be retained, got %v releases", len(retained))
+	}
+}
+func TestPromotePackageNotAllowed(t *testing.T) {
+	a, clean, err := makeTestArchive(t)
+	if err != nil {
+		return
+	}
+	defer clean()
+
+	a.SetDist("from", makeTestRelease(t, a, "from", testRemoveInput))
+
+	toid := makeTestRelease(t, a, "to", nil)
+	to, _ := a.GetRelease(toid)
+	cfg := *to.Config()
+	cfg.Architectures = []string{"amd64"}
+	to.ConfigID, _ = a.AddReleaseConfig(cfg)
+	toid, _ = a.AddRelease(to)
+	a.SetDist("to", toid)
+
+	// pkga 2-1 has an i386 binary
+	err = a.PromotePackage("from", "to", "pkga", MustParseDebVersion("2-1"))
+	if _, ok := err.(NotAllowedError); !ok {
+		t.Errorf("promoting a disallowed architecture should fail, got %v", err)
+	}
+
+	cfg.Components = []string{"contrib"}
+	to.ConfigID, _ = a.AddReleaseConfig(cfg)
+	to.config = nil
+	toid, _ = a.AddRelease(to)
+	a.SetDist("to", toid)
+
+	err = a.PromotePackage("from", "to", "pkga", MustParseDebVersion("1-1"))
+	if _, ok := err.(NotAllowedError); !ok {
+		t.Errorf("promoting to a disallowed component should fail, got %v", err)
+	}
+
+	if a.Dists()["to"].String() != toid.String() {
+		t.Errorf("rejected promotions should not change the target")
+	}
+}
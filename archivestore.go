@@ -22,6 +22,9 @@ type ArchiveStorer interface {
 	AddControlFile(data ControlFile) (StoreID, error)
 	GetControlFile(id StoreID) (ControlFile, error)
 
+	AddContents(files []string) (StoreID, error)
+	GetContents(id StoreID) ([]string, error)
+
 	GetReleaseRoot(seed Release) (StoreID, error)
 	AddRelease(data *Release) (StoreID, error)
 	GetRelease(id StoreID) (*Release, error)
@@ -82,6 +85,9 @@ func (r archiveBlobStore) gcWalkReleaseConfig(used *SafeMap, id StoreID) {
 func (r archiveBlobStore) gcWalkReleaseIndexEntryItem(used *SafeMap, item *ReleaseIndexEntryItem) {
 	ctrlid := item.ControlID
 	used.Set(ctrlid.String(), true)
+	if len(item.ContentsID) != 0 {
+		used.Set(item.ContentsID.String(), true)
+	}
 	for _, f := range item.Files {
 		used.Set(f.StoreID.String(), true)
 	}
@@ -134,8 +140,32 @@ func (r archiveBlobStore) gcWalkRelease(used *SafeMap, releaseID StoreID) {
 
 			for _, comp := range release.Components {
 				used.Set(comp.SourcesGz.String(), true)
+				for _, c := range comp.SourcesCompressed {
+					used.Set(c.ID.String(), true)
+				}
+				if len(comp.SourcesRelease) != 0 {
+					used.Set(comp.SourcesRelease.String(), true)
+				}
+				if len(comp.TranslationsGz) != 0 {
+					used.Set(comp.TranslationsGz.String(), true)
+				}
+				for _, c := range comp.TranslationsCompressed {
+					used.Set(c.ID.String(), true)
+				}
 				for _, arch := range comp.Architectures {
 					used.Set(arch.PackagesGz.String(), true)
+					for _, c := range arch.PackagesCompressed {
+						used.Set(c.ID.String(), true)
+					}
+					if len(arch.ContentsGz) != 0 {
+						used.Set(arch.ContentsGz.String(), true)
+					}
+					if len(arch.ReleaseFile) != 0 {
+						used.Set(arch.ReleaseFile.String(), true)
+					}
+					if len(arch.TranslationsPart) != 0 {
+						used.Set(arch.TranslationsPart.String(), true)
+					}
 				}
 			}
 
@@ -403,6 +433,39 @@ func (r archiveBlobStore) AddControlFile(item ControlFile) (StoreID, error) {
 	return id, nil
 }
 
+// AddContents stores the list of files installed by a package
+func (r archiveBlobStore) AddContents(files []string) (StoreID, error) {
+	writer, err := r.Store()
+	if err != nil {
+		return nil, err
+	}
+
+	err = gob.NewEncoder(writer).Encode(files)
+	if err != nil {
+		return nil, err
+	}
+
+	writer.Close()
+	return writer.Identity()
+}
+
+// GetContents retrieves a list of files stored with AddContents
+func (r archiveBlobStore) GetContents(id StoreID) ([]string, error) {
+	var files []string
+	reader, err := r.Open(id)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	err = gob.NewDecoder(reader).Decode(&files)
+	if err != nil {
+		return nil, fmt.Errorf("reading contents failed, %v", err)
+	}
+
+	return files, nil
+}
+
 func (r archiveBlobStore) EmptyReleaseIndex() (id StoreID, err error) {
 	idx, err := r.AddReleaseIndex()
 	return idx.Close()
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+)
+
+// publishByHashGenerations publishes the index files of a release, and
+// those of the configured number of previous releases, under by-hash
+// directories, so that clients part way through an update can still
+// retrieve the indexes they expect. Uncompressed indexes already published
+// under prevDistBase are reused, rather than decompressed again.
+func (a *archiveStoreArchive) publishByHashGenerations(distBase, prevDistBase string, release *Release) error {
+	err := a.publishByHash(distBase, prevDistBase, release, true)
+	if err != nil {
+		return err
+	}
+
+	curr := release
+	for i := 0; i < release.Config().ByHashGenerations; i++ {
+		if curr.ParentID.String() == a.EmptyFileID().String() {
+			break
+		}
+
+		curr, err = a.GetRelease(curr.ParentID)
+		if err != nil {
+			log.Printf("could not retrieve previous release for by-hash files, %v", err)
+			break
+		}
+
+		// The indexes of older releases may have been garbage collected
+		err = a.publishByHash(distBase, prevDistBase, curr, false)
+		if err != nil {
+			log.Printf("could not publish previous by-hash files, %v", err)
+			break
+		}
+	}
+
+	return nil
+}
+
+// publishByHash publishes the index files of a release under the by-hash
+// directory of each index. If current is set, the uncompressed indexes of
+// the release have already been published in distBase.
+func (a *archiveStoreArchive) publishByHash(distBase, prevDistBase string, release *Release, current bool) error {
+	for p, f := range releaseIndexFiles(release) {
+		dir := distBase + "/" + path.Dir(p)
+		byHashDir := dir + "/by-hash/SHA256/"
+
+		var err error
+		switch {
+		case f.sha256 == "" && f.gunzip:
+			// Releases from before the hashes of the index files were
+			// recorded
+			err = a.gunzipByHash(byHashDir, f.id)
+		case f.sha256 == "":
+			err = a.linkByHash(dir, f.id)
+		case f.gunzip:
+			copies := []string{prevDistBase + "/" + path.Dir(p) + "/by-hash/SHA256/" + f.sha256}
+			if current {
+				copies = append(copies, distBase+"/"+p)
+			}
+			err = a.publishUncompressedByHash(byHashDir, f.sha256, f.id, copies...)
+		default:
+			err = a.Link(f.id, byHashDir+f.sha256)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// publishUncompressedByHash publishes the uncompressed copy of an index to
+// dir. The uncompressed index is not kept in the store, so an existing copy
+// is linked if there is one, and the index is only decompressed if not.
+func (a *archiveStoreArchive) publishUncompressedByHash(dir, sum string, gzid StoreID, copies ...string) error {
+	if _, err := os.Stat(dir + sum); err == nil {
+		return nil
+	}
+
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range copies {
+		if err := os.Link(c, dir+sum); err == nil {
+			return nil
+		}
+	}
+
+	return a.gunzipByHash(dir, gzid)
+}
+
+// gunzipByHash decompresses a store item to dir, named by its SHA256 hash
+func (a *archiveStoreArchive) gunzipByHash(dir string, gzid StoreID) error {
+	rdr, err := a.Open(gzid)
+	if err != nil {
+		return err
+	}
+	defer rdr.Close()
+
+	gunzipper, err := gzip.NewReader(rdr)
+	if err != nil {
+		return err
+	}
+	defer gunzipper.Close()
+
+	return writeByHash(dir, gunzipper)
+}
+
+// linkByHash links a store item into dir/by-hash/SHA256
+func (a *archiveStoreArchive) linkByHash(dir string, id StoreID) error {
+	rdr, err := a.Open(id)
+	if err != nil {
+		return err
+	}
+	sum, err := sha256Hex(rdr)
+	rdr.Close()
+	if err != nil {
+		return err
+	}
+
+	return a.Link(id, dir+"/by-hash/SHA256/"+sum)
+}
+
+// writeByHash writes the content of r to dir, named by its SHA256 hash
+func writeByHash(dir string, r io.Reader) error {
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	hasher := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, hasher), r)
+	tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	name := dir + hex.EncodeToString(hasher.Sum(nil))
+	if _, err := os.Stat(name); err == nil {
+		return nil
+	}
+
+	return os.Rename(tmp.Name(), name)
+}
+
+func sha256Hex(r io.Reader) (string, error) {
+	hasher := sha256.New()
+	_, err := io.Copy(hasher, r)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func storeTestGz(t *testing.T, a *archiveStoreArchive, content string) (StoreID, string, string) {
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	gz.Write([]byte(content))
+	gz.Close()
+
+	w, err := a.Store()
+	if err != nil {
+		t.Fatalf("creating blob failed, %v", err)
+	}
+	gzSum := sha256.Sum256(buf.Bytes())
+	w.Write(buf.Bytes())
+	w.Close()
+	id, _ := w.Identity()
+
+	plainSum := sha256.Sum256([]byte(content))
+	return id, hex.EncodeToString(gzSum[:]), hex.EncodeToString(plainSum[:])
+}
+
+func TestPublishByHash(t *testing.T) {
+	a, clean, err := makeTestArchive(t)
+	if err != nil {
+		return
+	}
+	defer clean()
+
+	oldid, oldGzSum, oldSum := storeTestGz(t, a, "Package: old\n")
+	newid, newGzSum, newSum := storeTestGz(t, a, "Package: new\n")
+
+	relid := makeTestRelease(t, a, "test", nil)
+	rel, _ := a.GetRelease(relid)
+	rel.Components = []Component{{
+		Name:          "main",
+		Architectures: []Architecture{{Name: "amd64", PackagesGz: oldid}},
+		SourcesGz:     oldid,
+	}}
+	cfg := *rel.Config()
+	cfg.ByHashGenerations = 1
+	rel.ConfigID, _ = a.AddReleaseConfig(cfg)
+	relid, _ = a.AddRelease(rel)
+	rel, _ = a.GetRelease(relid)
+
+	child := rel.NewChild()
+	child.Components = []Component{{
+		Name:          "main",
+		Architectures: []Architecture{{Name: "amd64", PackagesGz: newid}},
+		SourcesGz:     newid,
+	}}
+	childid, _ := a.AddRelease(child)
+	child, _ = a.GetRelease(childid)
+
+	distBase := *a.base + "/dists/test"
+	err = a.publishByHashGenerations(distBase, "", child)
+	if err != nil {
+		t.Fatalf("publishing by-hash files failed, %v", err)
+	}
+
+	for _, dir := range []string{"/main/source", "/main/binary-amd64"} {
+		for _, sum := range []string{oldGzSum, oldSum, newGzSum, newSum} {
+			if _, err := os.Stat(distBase + dir + "/by-hash/SHA256/" + sum); err != nil {
+				t.Errorf("by-hash file missing, %v", err)
+			}
+		}
+	}
+
+	// Only the current generation is kept if no history is requested
+	os.RemoveAll(distBase)
+	cfg.ByHashGenerations = 0
+	child.ConfigID, _ = a.AddReleaseConfig(cfg)
+	child.config = nil
+
+	err = a.publishByHashGenerations(distBase, "", child)
+	if err != nil {
+		t.Fatalf("publishing by-hash files failed, %v", err)
+	}
+
+	if _, err := os.Stat(distBase + "/main/source/by-hash/SHA256/" + newSum); err != nil {
+		t.Errorf("by-hash file missing, %v", err)
+	}
+	if _, err := os.Stat(distBase + "/main/source/by-hash/SHA256/" + oldSum); err == nil {
+		t.Errorf("previous generation should not have been published")
+	}
+}
+
+func TestPublishByHashRecorded(t *testing.T) {
+	a, clean, err := makeTestArchive(t)
+	if err != nil {
+		return
+	}
+	defer clean()
+
+	gzid, _, sum := storeTestGz(t, a, "Package: pkga\n")
+
+	relid := makeTestRelease(t, a, "test", nil)
+	rel, _ := a.GetRelease(relid)
+	rel.Components = []Component{{
+		Name: "main",
+		Architectures: []Architecture{{
+			Name:       "amd64",
+			PackagesGz: gzid,
+			IndexFiles: []IndexFile{
+				{Path: "binary-amd64/Packages", SHA256: sum},
+				{Path: "binary-amd64/Packages.gz", SHA256: "recordedgz"},
+			},
+		}},
+		SourcesGz: gzid,
+	}}
+	relid, _ = a.AddRelease(rel)
+	rel, _ = a.GetRelease(relid)
+
+	// An uncompressed copy that has already been published is reused
+	prevDistBase := *a.base + "/prev"
+	prevCopy := prevDistBase + "/main/binary-amd64/by-hash/SHA256/" + sum
+	os.MkdirAll(prevDistBase+"/main/binary-amd64/by-hash/SHA256", 0777)
+	ioutil.WriteFile(prevCopy, []byte("Package: pkga\n"), 0666)
+
+	distBase := *a.base + "/dists/test"
+	err = a.publishByHashGenerations(distBase, prevDistBase, rel)
+	if err != nil {
+		t.Fatalf("publishing by-hash files failed, %v", err)
+	}
+
+	byHashDir := distBase + "/main/binary-amd64/by-hash/SHA256/"
+
+	// The recorded hashes are used, rather than hashing the files again
+	if _, err := os.Stat(byHashDir + "recordedgz"); err != nil {
+		t.Errorf("by-hash file should be named by its recorded hash, %v", err)
+	}
+
+	prevInfo, _ := os.Stat(prevCopy)
+	info, err := os.Stat(byHashDir + sum)
+	if err != nil || !os.SameFile(prevInfo, info) {
+		t.Errorf("previously published uncompressed index should have been linked, %v", err)
+	}
+}
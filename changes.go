@@ -3,6 +3,7 @@ package main
 import (
 	//"code.google.com/p/go.crypto/openpgp"
 
+	"bytes"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -205,6 +206,96 @@ func ParseDebianChanges(r io.Reader, kr openpgp.EntityList) (p ChangesFile, err
 	}, nil
 }
 
+// FileSection returns the section listed for the named file in the Files
+// field of the changes file, or an empty string if none is given
+func (c *ChangesFile) FileSection(name string) string {
+	if len(c.Control.Data) == 0 {
+		return ""
+	}
+
+	files, ok := c.Control.Data[0].GetValues("Files")
+	if !ok {
+		return ""
+	}
+
+	for _, f := range files[1:] {
+		fileDesc := strings.Fields(*f)
+		if len(fileDesc) == 5 && fileDesc[4] == name {
+			if fileDesc[2] == "-" {
+				return ""
+			}
+			return fileDesc[2]
+		}
+	}
+
+	return ""
+}
+
+// SourceDsc returns the name of the dsc file listed in the changes
+// file, or an empty string if there is none
+func (c *ChangesFile) SourceDsc() string {
+	for k := range c.FileHashes {
+		if strings.HasSuffix(k.Name, ".dsc") {
+			return k.Name
+		}
+	}
+	return ""
+}
+
+// VerifyDsc checks that every file listed in the Files and Checksums
+// fields of a dsc is also listed in the changes file, with the same size
+// and hashes. As uploaded files are checked against the changes file, this
+// ensures the source package is complete and matches what was uploaded.
+func (c *ChangesFile) VerifyDsc(dsc ControlFile) error {
+	if len(dsc.Data) != 1 {
+		return errors.New("Wrong number of paragraphs in dsc file")
+	}
+
+	files, err := changesFileHashes(dsc.Data[0])
+	if err != nil {
+		return errors.New("Invalid dsc, " + err.Error())
+	}
+	if len(files) == 0 {
+		return errors.New("Dsc does not list any source files")
+	}
+
+	for dscIdx, dscHashes := range files {
+		var idx ChangesFilesIndex
+		ok := false
+		for k := range c.FileHashes {
+			if k.Name == dscIdx.Name {
+				idx, ok = k, true
+				break
+			}
+		}
+		if !ok {
+			return fmt.Errorf("Source upload is incomplete, %v is listed in the dsc but not in the changes file", dscIdx.Name)
+		}
+
+		if idx.Size != dscIdx.Size {
+			return fmt.Errorf("Size of %v in the dsc does not match the changes file", dscIdx.Name)
+		}
+
+		for hName, h := range dscHashes {
+			if ch, ok := c.FileHashes[idx][hName]; ok && !bytes.Equal(ch, h) {
+				return fmt.Errorf("%v hash of %v in the dsc does not match the changes file", hName, dscIdx.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// SectionComponent returns the component implied by a section. Sections
+// in components other than main are prefixed with the component name
+// (e.g. contrib/net)
+func SectionComponent(section string) string {
+	if i := strings.Index(section, "/"); i > 0 {
+		return section[:i]
+	}
+	return "main"
+}
+
 // ChangesFromHTTPRequest seperates a changes file from any other files in a
 // http request
 func ChangesFromHTTPRequest(r *http.Request) (
@@ -35,6 +35,81 @@ func TestParseDebianChanges(t *testing.T) {
 	}
 }
 
+func TestChangesFileSection(t *testing.T) {
+	c, err := ParseDebianChanges(strings.NewReader(strings.Replace(testChanges1,
+		"1050 utils extra whacky-package-assets",
+		"1050 contrib/utils extra whacky-package-assets", 1)), nil)
+	if err != nil {
+		t.Fatalf("parsing changes failed, %v", err)
+	}
+
+	tests := []struct {
+		file      string
+		section   string
+		component string
+	}{
+		{"whacky-package_1.0.0.dsc", "utils", "main"},
+		{"whacky-package-assets_1.0.0_amd64.deb", "contrib/utils", "contrib"},
+		{"unknown_1.0.0_amd64.deb", "", "main"},
+	}
+
+	for _, tt := range tests {
+		section := c.FileSection(tt.file)
+		if section != tt.section {
+			t.Errorf("expected section %q for %v, got %q", tt.section, tt.file, section)
+		}
+		if comp := SectionComponent(section); comp != tt.component {
+			t.Errorf("expected component %q for %v, got %q", tt.component, tt.file, comp)
+		}
+	}
+}
+
+func TestChangesVerifyDsc(t *testing.T) {
+	c, err := ParseDebianChanges(strings.NewReader(testChanges1), nil)
+	if err != nil {
+		t.Fatalf("parsing changes failed, %v", err)
+	}
+
+	tests := []struct {
+		dsc string
+		err bool
+	}{
+		{testDsc1, false},
+		{strings.Replace(testDsc1, "2ff43945d0c4610f79dd23146708f196", "2ff43945d0c4610f79dd23146708f197", 1), true},
+		{strings.Replace(testDsc1, "7f1df53706f434b762f274c7b1ad84bdb624681350fdbf009f4afff33f1bf5c7", "7f1df53706f434b762f274c7b1ad84bdb624681350fdbf009f4afff33f1bf5c8", 1), true},
+		{strings.Replace(testDsc1, "38524512 whacky-package_1.0.0.tar.gz", "38524513 whacky-package_1.0.0.tar.gz", 3), true},
+		{testDsc1 + "\n 3d5f8ab0c9be5a4ba1a2c5ac3d7a4e2f 1024 whacky-package_1.0.0.debian.tar.gz", true},
+		{"Format: 3.0 (native)\nSource: whacky-package\n", true},
+	}
+
+	for i, tt := range tests {
+		dsc, err := ParseDebianControl(strings.NewReader(tt.dsc), nil)
+		if err != nil {
+			t.Fatalf("%d. parsing dsc failed, %v", i, err)
+		}
+		err = c.VerifyDsc(dsc)
+		if tt.err && err == nil {
+			t.Errorf("%d. expected dsc verification to fail", i)
+		}
+		if !tt.err && err != nil {
+			t.Errorf("%d. dsc verification failed, %v", i, err)
+		}
+	}
+}
+
+var testDsc1 = `Format: 3.0 (native)
+Source: whacky-package
+Binary: whacky-package, whacky-package-assets
+Architecture: amd64
+Version: 1.0.0
+Maintainer: Tristan Colgate-McFarlane <TristanC@acme.com>
+Checksums-Sha1: 
+ 778f77115127a934afbf1d2ac7086eaeb3adced9 38524512 whacky-package_1.0.0.tar.gz
+Checksums-Sha256: 
+ 7f1df53706f434b762f274c7b1ad84bdb624681350fdbf009f4afff33f1bf5c7 38524512 whacky-package_1.0.0.tar.gz
+Files: 
+ 2ff43945d0c4610f79dd23146708f196 38524512 whacky-package_1.0.0.tar.gz`
+
 var testChangesInvalid = `Just some random malformed rubbish`
 
 var testChangesMissing = `Format: 1.8
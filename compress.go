@@ -0,0 +1,175 @@
+package main
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// indexCompressors maps the extension of the additional compressed index
+// files we can generate to the external command used to compress them.
+// gzip compressed indexes are always generated.
+var indexCompressors = map[string][]string{
+	"bz2": {"bzip2", "-c"},
+	"xz":  {"xz", "-c"},
+}
+
+// CompressedIndex is an additional compressed copy of an index file
+type CompressedIndex struct {
+	Compression string // The file extension for the compression
+	ID          StoreID
+}
+
+// ParseIndexCompressions parses a comma seperated list of additional
+// index compressions
+func ParseIndexCompressions(str string) ([]string, error) {
+	comps := []string{}
+	for _, c := range strings.Split(str, ",") {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		comps = append(comps, c)
+	}
+
+	return comps, CheckIndexCompressions(comps)
+}
+
+// CheckIndexCompressions checks that each of the compressions is known, and
+// that the command needed to generate it is available
+func CheckIndexCompressions(comps []string) error {
+	seen := map[string]bool{}
+	for _, c := range comps {
+		cmd, ok := indexCompressors[c]
+		if !ok {
+			var known []string
+			for k := range indexCompressors {
+				known = append(known, k)
+			}
+			sort.Strings(known)
+			return fmt.Errorf("unknown index compression %v, must be one of %v", c, strings.Join(known, ", "))
+		}
+		if seen[c] {
+			return fmt.Errorf("index compression %v given more than once", c)
+		}
+		seen[c] = true
+
+		if _, err := exec.LookPath(cmd[0]); err != nil {
+			return fmt.Errorf("index compression %v is not available, %v", c, err)
+		}
+	}
+	return nil
+}
+
+// compressWriter compresses the data written to it by piping it through
+// an external command
+type compressWriter struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+}
+
+// NewCompressWriter returns a writer that will write data compressed with
+// the named compression to w. The writer must be closed to flush the
+// compressed data
+func NewCompressWriter(compression string, w io.Writer) (io.WriteCloser, error) {
+	args, ok := indexCompressors[compression]
+	if !ok {
+		return nil, errors.New("unknown compression " + compression)
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = w
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return nil, fmt.Errorf("starting %v failed, %v", args[0], err)
+	}
+
+	return &compressWriter{cmd: cmd, stdin: stdin}, nil
+}
+
+func (c *compressWriter) Write(p []byte) (int, error) {
+	return c.stdin.Write(p)
+}
+
+// Close finishes the compression, and waits for the compressor to exit
+func (c *compressWriter) Close() error {
+	c.stdin.Close()
+	err := c.cmd.Wait()
+	if err != nil {
+		return fmt.Errorf("%v failed, %v", c.cmd.Args[0], err)
+	}
+	return nil
+}
+
+// decompressors maps file extensions to the external command used to
+// decompress them, for compressions not supported by the standard library
+var decompressors = map[string][]string{
+	"xz":  {"xz", "-dc"},
+	"zst": {"zstd", "-dc"},
+}
+
+// decompressReader decompresses the data read from it by piping it through
+// an external command
+type decompressReader struct {
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+}
+
+// NewDecompressReader returns a reader that decompresses the data from r,
+// compressed with the compression indicated by the file extension ext. The
+// reader must be closed to release the decompressor
+func NewDecompressReader(ext string, r io.Reader) (io.ReadCloser, error) {
+	switch ext {
+	case "":
+		return ioutil.NopCloser(r), nil
+	case "gz":
+		return gzip.NewReader(r)
+	case "bz2":
+		return ioutil.NopCloser(bzip2.NewReader(r)), nil
+	}
+
+	args, ok := decompressors[ext]
+	if !ok {
+		return nil, errors.New("unknown compression " + ext)
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = r
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return nil, fmt.Errorf("starting %v failed, %v", args[0], err)
+	}
+
+	return &decompressReader{cmd: cmd, stdout: stdout}, nil
+}
+
+func (d *decompressReader) Read(p []byte) (int, error) {
+	return d.stdout.Read(p)
+}
+
+// Close discards any remaining output and waits for the decompressor
+// to exit
+func (d *decompressReader) Close() error {
+	io.Copy(ioutil.Discard, d.stdout)
+	err := d.cmd.Wait()
+	if err != nil {
+		return fmt.Errorf("%v failed, %v", d.cmd.Args[0], err)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"io/ioutil"
+	"os/exec"
+	"testing"
+)
+
+func TestCompressWriter(t *testing.T) {
+	input := []byte("Package: test\nVersion: 1.0\n\n")
+
+	for _, compression := range []string{"xz", "bz2"} {
+		if err := CheckIndexCompressions([]string{compression}); err != nil {
+			t.Logf("skipping %v, %v", compression, err)
+			continue
+		}
+
+		buf := &bytes.Buffer{}
+		w, err := NewCompressWriter(compression, buf)
+		if err != nil {
+			t.Errorf("creating %v writer failed, %v", compression, err)
+			continue
+		}
+		w.Write(input)
+		err = w.Close()
+		if err != nil {
+			t.Errorf("%v compression failed, %v", compression, err)
+			continue
+		}
+
+		var output []byte
+		switch compression {
+		case "bz2":
+			output, err = ioutil.ReadAll(bzip2.NewReader(buf))
+		case "xz":
+			cmd := exec.Command("xz", "-dc")
+			cmd.Stdin = buf
+			output, err = cmd.Output()
+		}
+		if err != nil {
+			t.Errorf("%v decompression failed, %v", compression, err)
+			continue
+		}
+
+		if !bytes.Equal(input, output) {
+			t.Errorf("%v round trip failed, expected %q, got %q", compression, input, output)
+		}
+	}
+}
+
+func TestParseIndexCompressions(t *testing.T) {
+	comps, err := ParseIndexCompressions("")
+	if err != nil || len(comps) != 0 {
+		t.Errorf("empty compression list should be valid, got %v, %v", comps, err)
+	}
+
+	if _, err := ParseIndexCompressions("xz,zip"); err == nil {
+		t.Errorf("unknown compression should be rejected")
+	}
+
+	if _, err := ParseIndexCompressions("bz2, bz2"); err == nil {
+		t.Errorf("repeated compression should be rejected")
+	}
+}
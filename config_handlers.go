@@ -7,7 +7,9 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"reflect"
 	"sort"
+	"strings"
 
 	"code.google.com/p/go.crypto/openpgp"
 	"github.com/gorilla/mux"
@@ -45,6 +47,72 @@ func doHTTPConfigGetHandler(ctx context.Context, w http.ResponseWriter, r *http.
 	}
 }
 
+// policyUpdate holds the prune and trim settings of a config update. The
+// fields are pointers to determine if they were included or left out.
+type policyUpdate struct {
+	PruneRules     *string
+	AutoTrim       *bool
+	AutoTrimLength *int
+	AutoTrimAge    *string
+}
+
+// apply validates the prune and trim settings, and applies any that differ
+// to cfg, returning log actions describing the changes
+func (d *policyUpdate) apply(cfg *ReleaseConfig) ([]ReleaseLogAction, error) {
+	var acts []ReleaseLogAction
+
+	if d.PruneRules != nil {
+		rules, err := ParsePruneRules(*d.PruneRules)
+		if err != nil {
+			return nil, err
+		}
+		*d.PruneRules = rules.String()
+	}
+
+	if d.PruneRules != nil && *d.PruneRules != cfg.PruneRules {
+		acts = append(acts, ReleaseLogAction{
+			Type:        ActionCONFIGCHANGE,
+			Description: fmt.Sprintf("PruneRules changed from %v to %v", cfg.PruneRules, *d.PruneRules),
+		})
+		cfg.PruneRules = *d.PruneRules
+		cfg.pruneRules = nil
+	}
+
+	if d.AutoTrim != nil && *d.AutoTrim != cfg.AutoTrim {
+		acts = append(acts, ReleaseLogAction{
+			Type:        ActionCONFIGCHANGE,
+			Description: fmt.Sprintf("AutoTrim changed from %v to %v", cfg.AutoTrim, *d.AutoTrim),
+		})
+		cfg.AutoTrim = *d.AutoTrim
+	}
+
+	if d.AutoTrimLength != nil && *d.AutoTrimLength != cfg.AutoTrimLength {
+		if *d.AutoTrimLength < 0 {
+			return nil, errors.New("AutoTrimLength must not be negative")
+		}
+		acts = append(acts, ReleaseLogAction{
+			Type:        ActionCONFIGCHANGE,
+			Description: fmt.Sprintf("AutoTrimLength changed from %v to %v", cfg.AutoTrimLength, *d.AutoTrimLength),
+		})
+		cfg.AutoTrimLength = *d.AutoTrimLength
+	}
+
+	if d.AutoTrimAge != nil && *d.AutoTrimAge != cfg.AutoTrimAge {
+		if *d.AutoTrimAge != "" {
+			if _, err := ParseAge(*d.AutoTrimAge); err != nil {
+				return nil, err
+			}
+		}
+		acts = append(acts, ReleaseLogAction{
+			Type:        ActionCONFIGCHANGE,
+			Description: fmt.Sprintf("AutoTrimAge changed from %v to %v", cfg.AutoTrimAge, *d.AutoTrimAge),
+		})
+		cfg.AutoTrimAge = *d.AutoTrimAge
+	}
+
+	return acts, nil
+}
+
 // This build a function to update the config of a distribution
 func doHTTPConfigPutHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) *appError {
 	if !AuthorisedAdmin(ctx, w, r) {
@@ -54,13 +122,24 @@ func doHTTPConfigPutHandler(ctx context.Context, w http.ResponseWriter, r *http.
 	// We use this to determine if the fields in the
 	// update were included or left out
 	type configUpdate struct {
-		PruneRules              *string
+		policyUpdate
 		VerifyChanges           *bool
 		AcceptLoneDebs          *bool
 		PoolPattern             *string
 		VerifyDebs              *bool
-		AutoTrimLength          *int
-		AutoTrim                *bool
+		VerifyDscs              *bool
+		IndexCompressions       *[]string
+		ByHashGenerations       *int
+		StripLongDescriptions   *bool
+		Components              *[]string
+		Architectures           *[]string
+		SeparateArchAll         *bool
+		Origin                  *string
+		Label                   *string
+		Description             *string
+		ValidFor                *string
+		NotAutomatic            *bool
+		ButAutomaticUpgrades    *bool
 		VerifyChangesSufficient *bool
 	}
 
@@ -85,14 +164,9 @@ func doHTTPConfigPutHandler(ctx context.Context, w http.ResponseWriter, r *http.
 		return sendResponse(w, http.StatusBadRequest, nil)
 	}
 
-	var acts []ReleaseLogAction
-
-	if d.PruneRules != nil && *d.PruneRules != cfg.PruneRules {
-		acts = append(acts, ReleaseLogAction{
-			Type:        ActionCONFIGCHANGE,
-			Description: fmt.Sprintf("PruneRules changed from %v to %v", cfg.PruneRules, *d.PruneRules),
-		})
-		cfg.PruneRules = *d.PruneRules
+	acts, err := d.policyUpdate.apply(cfg)
+	if err != nil {
+		return sendResponse(w, http.StatusBadRequest, err.Error())
 	}
 
 	if d.VerifyChanges != nil && *d.VerifyChanges != cfg.VerifyChanges {
@@ -127,20 +201,138 @@ func doHTTPConfigPutHandler(ctx context.Context, w http.ResponseWriter, r *http.
 		cfg.VerifyDebs = *d.VerifyDebs
 	}
 
-	if d.AutoTrimLength != nil && *d.AutoTrimLength != cfg.AutoTrimLength {
+	if d.VerifyDscs != nil && *d.VerifyDscs != cfg.VerifyDscs {
 		acts = append(acts, ReleaseLogAction{
 			Type:        ActionCONFIGCHANGE,
-			Description: fmt.Sprintf("AutoTrimLength changed from %v to %v", cfg.AutoTrimLength, *d.AutoTrimLength),
+			Description: fmt.Sprintf("VerifyDscs changed from %v to %v", cfg.VerifyDscs, *d.VerifyDscs),
 		})
-		cfg.AutoTrimLength = *d.AutoTrimLength
+		cfg.VerifyDscs = *d.VerifyDscs
 	}
 
-	if d.AutoTrim != nil && *d.AutoTrim != cfg.AutoTrim {
+	if d.IndexCompressions != nil && !reflect.DeepEqual(*d.IndexCompressions, cfg.IndexCompressions) {
+		if err := CheckIndexCompressions(*d.IndexCompressions); err != nil {
+			return sendResponse(w, http.StatusBadRequest, err.Error())
+		}
 		acts = append(acts, ReleaseLogAction{
 			Type:        ActionCONFIGCHANGE,
-			Description: fmt.Sprintf("AutoTrim changed from %v to %v", cfg.AutoTrim, *d.AutoTrim),
+			Description: fmt.Sprintf("IndexCompressions changed from %v to %v", cfg.IndexCompressions, *d.IndexCompressions),
 		})
-		cfg.AutoTrim = *d.AutoTrim
+		cfg.IndexCompressions = *d.IndexCompressions
+	}
+
+	if d.ByHashGenerations != nil && *d.ByHashGenerations != cfg.ByHashGenerations {
+		if *d.ByHashGenerations < 0 {
+			return sendResponse(w, http.StatusBadRequest, "ByHashGenerations must not be negative")
+		}
+		acts = append(acts, ReleaseLogAction{
+			Type:        ActionCONFIGCHANGE,
+			Description: fmt.Sprintf("ByHashGenerations changed from %v to %v", cfg.ByHashGenerations, *d.ByHashGenerations),
+		})
+		cfg.ByHashGenerations = *d.ByHashGenerations
+	}
+
+	if d.Components != nil && !reflect.DeepEqual(*d.Components, cfg.Components) {
+		if err := CheckComponents(*d.Components); err != nil {
+			return sendResponse(w, http.StatusBadRequest, err.Error())
+		}
+		acts = append(acts, ReleaseLogAction{
+			Type:        ActionCONFIGCHANGE,
+			Description: fmt.Sprintf("Components changed from %v to %v", cfg.Components, *d.Components),
+		})
+		cfg.Components = *d.Components
+	}
+
+	if d.Architectures != nil && !reflect.DeepEqual(*d.Architectures, cfg.Architectures) {
+		if err := CheckArchitectures(*d.Architectures); err != nil {
+			return sendResponse(w, http.StatusBadRequest, err.Error())
+		}
+		acts = append(acts, ReleaseLogAction{
+			Type:        ActionCONFIGCHANGE,
+			Description: fmt.Sprintf("Architectures changed from %v to %v", cfg.Architectures, *d.Architectures),
+		})
+		cfg.Architectures = *d.Architectures
+	}
+
+	if d.SeparateArchAll != nil && *d.SeparateArchAll != cfg.SeparateArchAll {
+		acts = append(acts, ReleaseLogAction{
+			Type:        ActionCONFIGCHANGE,
+			Description: fmt.Sprintf("SeparateArchAll changed from %v to %v", cfg.SeparateArchAll, *d.SeparateArchAll),
+		})
+		cfg.SeparateArchAll = *d.SeparateArchAll
+	}
+
+	if d.Origin != nil && *d.Origin != cfg.Origin {
+		if strings.ContainsAny(*d.Origin, "\r\n") {
+			return sendResponse(w, http.StatusBadRequest, "Origin must be a single line")
+		}
+		acts = append(acts, ReleaseLogAction{
+			Type:        ActionCONFIGCHANGE,
+			Description: fmt.Sprintf("Origin changed from %v to %v", cfg.Origin, *d.Origin),
+		})
+		cfg.Origin = *d.Origin
+	}
+
+	if d.Label != nil && *d.Label != cfg.Label {
+		if strings.ContainsAny(*d.Label, "\r\n") {
+			return sendResponse(w, http.StatusBadRequest, "Label must be a single line")
+		}
+		acts = append(acts, ReleaseLogAction{
+			Type:        ActionCONFIGCHANGE,
+			Description: fmt.Sprintf("Label changed from %v to %v", cfg.Label, *d.Label),
+		})
+		cfg.Label = *d.Label
+	}
+
+	if d.Description != nil && *d.Description != cfg.Description {
+		if strings.ContainsAny(*d.Description, "\r\n") {
+			return sendResponse(w, http.StatusBadRequest, "Description must be a single line")
+		}
+		acts = append(acts, ReleaseLogAction{
+			Type:        ActionCONFIGCHANGE,
+			Description: fmt.Sprintf("Description changed from %v to %v", cfg.Description, *d.Description),
+		})
+		cfg.Description = *d.Description
+	}
+
+	if d.ValidFor != nil && *d.ValidFor != cfg.ValidFor {
+		if *d.ValidFor != "" {
+			if err := CheckValidFor(*d.ValidFor, state.RefreshInterval); err != nil {
+				return sendResponse(w, http.StatusBadRequest, err.Error())
+			}
+		}
+		acts = append(acts, ReleaseLogAction{
+			Type:        ActionCONFIGCHANGE,
+			Description: fmt.Sprintf("ValidFor changed from %v to %v", cfg.ValidFor, *d.ValidFor),
+		})
+		cfg.ValidFor = *d.ValidFor
+	}
+
+	if d.NotAutomatic != nil && *d.NotAutomatic != cfg.NotAutomatic {
+		acts = append(acts, ReleaseLogAction{
+			Type:        ActionCONFIGCHANGE,
+			Description: fmt.Sprintf("NotAutomatic changed from %v to %v", cfg.NotAutomatic, *d.NotAutomatic),
+		})
+		cfg.NotAutomatic = *d.NotAutomatic
+	}
+
+	if d.ButAutomaticUpgrades != nil && *d.ButAutomaticUpgrades != cfg.ButAutomaticUpgrades {
+		acts = append(acts, ReleaseLogAction{
+			Type:        ActionCONFIGCHANGE,
+			Description: fmt.Sprintf("ButAutomaticUpgrades changed from %v to %v", cfg.ButAutomaticUpgrades, *d.ButAutomaticUpgrades),
+		})
+		cfg.ButAutomaticUpgrades = *d.ButAutomaticUpgrades
+	}
+
+	if cfg.ButAutomaticUpgrades && !cfg.NotAutomatic {
+		return sendResponse(w, http.StatusBadRequest, "ButAutomaticUpgrades requires NotAutomatic")
+	}
+
+	if d.StripLongDescriptions != nil && *d.StripLongDescriptions != cfg.StripLongDescriptions {
+		acts = append(acts, ReleaseLogAction{
+			Type:        ActionCONFIGCHANGE,
+			Description: fmt.Sprintf("StripLongDescriptions changed from %v to %v", cfg.StripLongDescriptions, *d.StripLongDescriptions),
+		})
+		cfg.StripLongDescriptions = *d.StripLongDescriptions
 	}
 
 	if d.VerifyChangesSufficient != nil && *d.VerifyChangesSufficient != cfg.VerifyChangesSufficient {
@@ -151,14 +343,41 @@ func doHTTPConfigPutHandler(ctx context.Context, w http.ResponseWriter, r *http.
 		cfg.VerifyChangesSufficient = *d.VerifyChangesSufficient
 	}
 
+	if r.URL.Query().Get("dry-run") == "true" {
+		preview, err := PreviewPolicy(state.Archive, rel, cfg)
+		if err != nil {
+			return &appError{Error: err}
+		}
+		if acts == nil {
+			acts = []ReleaseLogAction{}
+		}
+
+		return sendOKResponse(w, struct {
+			Actions []ReleaseLogAction
+			Preview *PolicyPreview
+		}{acts, preview})
+	}
+
+	// Apply the new prune rules to the existing index if requested, rather
+	// than waiting for the next upload
+	prune := d.PruneRules != nil && r.URL.Query().Get("prune") == "true"
+	indexid := rel.IndexID
+	if prune {
+		var pruneActs []ReleaseLogAction
+		indexid, pruneActs, err = state.Archive.PruneIndex(rel.IndexID, cfg)
+		if err != nil {
+			return &appError{
+				Error: errors.New("failed to prune distribution, " + err.Error()),
+			}
+		}
+		acts = append(acts, pruneActs...)
+	}
+
 	if len(acts) == 0 {
 		// No actions, do nothing
 		return doHTTPConfigGetHandler(ctx, w, r)
 	}
 
-	n := rel.NewChild()
-	n.Actions = acts
-
 	newcfgid, err := state.Archive.AddReleaseConfig(*cfg)
 	if err != nil {
 		return &appError{
@@ -166,27 +385,36 @@ func doHTTPConfigPutHandler(ctx context.Context, w http.ResponseWriter, r *http.
 		}
 	}
 
-	n.ConfigID = newcfgid
-	n.updateReleasefiles()
+	update := func() error {
+		newrelid, err := newChildRelease(state.Archive, rel, indexid, newcfgid, acts)
+		if err != nil {
+			return fmt.Errorf("Creating config commit failed, %v", err)
+		}
 
-	newrelid, err := state.Archive.AddRelease(n)
-	if err != nil {
-		return &appError{
-			Error: errors.New("failed to update key, " + err.Error()),
+		if err = state.Archive.SetDist(name, newrelid); err != nil {
+			return fmt.Errorf("Setting dist ref failed, %v", err)
 		}
-	}
 
-	err = state.Archive.SetDist(name, newrelid)
-	if err != nil {
-		return &appError{
-			Error: errors.New("failed to update key, " + err.Error()),
+		if err = state.Archive.ReifyRelease(newrelid); err != nil {
+			return fmt.Errorf("Repopulating the archive directory failed, %v", err)
 		}
+
+		if prune {
+			state.Archive.GarbageCollect()
+		}
+		return nil
 	}
 
-	err = state.Archive.ReifyRelease(newrelid)
+	// Pruning changes the content of the distribution, so the generation
+	// hooks are run, as they are for a prune request
+	if prune {
+		_, err = updateWithGenHooks(name, update)
+	} else {
+		err = update()
+	}
 	if err != nil {
 		return &appError{
-			Error: errors.New("failed to update key, " + err.Error()),
+			Error: errors.New("failed to update config, " + err.Error()),
 		}
 	}
 
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log"
 	"reflect"
 	"strconv"
 	"strings"
@@ -36,6 +37,8 @@ type DebPackageInfoer interface {
 	Sha1() ([]byte, error)
 	Sha256() ([]byte, error)
 	Size() (int64, error)
+
+	Files() ([]string, error) // The files installed by the package
 }
 
 // A set of signatures as contained in a dpkg-gig signed package
@@ -73,6 +76,7 @@ type debPackage struct {
 	size   int64  // Package size
 
 	controlMap *ControlParagraph // This content of the debian/control file
+	files      []string          // The files contained in data.tar
 }
 
 // This parses a dpkg-deb signature file. These are files named _gpg.*
@@ -286,6 +290,21 @@ func (d *debPackage) Sha256() ([]byte, error) {
 	return d.sha256, nil
 }
 
+// Files returns the list of files installed by the package. The list
+// will be empty if the data.tar could not be read
+func (d *debPackage) Files() ([]string, error) {
+	var err error
+
+	if !d.parsed {
+		err = d.parseDebPackage()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return d.files, nil
+}
+
 func (d *debPackage) Size() (int64, error) {
 	var err error
 
@@ -370,6 +389,18 @@ func (d *debPackage) parseDebPackage() (err error) {
 					io.Copy(&controlBytes, tarReader)
 				}
 			}
+		case strings.HasPrefix(arfn, "data.tar"):
+			// This file contains the package content, we collect the
+			// list of files for the Contents indexes
+			tee := io.TeeReader(arReader, hasher)
+
+			d.files, err = readDataTarFiles(strings.TrimPrefix(strings.TrimPrefix(arfn, "data.tar"), "."), tee)
+			if err != nil {
+				log.Printf("could not read file list from %v, %v", arfn, err)
+			}
+
+			// Make sure the hasher has seen the whole file
+			io.Copy(ioutil.Discard, tee)
 		default:
 			// We copy other files so that the hasher builds the hash
 			// that we can then verify against the sigs in any sigs file
@@ -426,6 +457,38 @@ func (d *debPackage) parseDebPackage() (err error) {
 	return nil
 }
 
+// readDataTarFiles lists the files in a, possibly compressed, data.tar
+func readDataTarFiles(compression string, r io.Reader) ([]string, error) {
+	dr, err := NewDecompressReader(compression, r)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	tarReader := tar.NewReader(dr)
+	for {
+		tarHeader, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			dr.Close()
+			return nil, err
+		}
+
+		if tarHeader.Typeflag == tar.TypeDir {
+			continue
+		}
+
+		name := strings.TrimPrefix(strings.TrimPrefix(tarHeader.Name, "."), "/")
+		if name != "" {
+			files = append(files, name)
+		}
+	}
+
+	return files, dr.Close()
+}
+
 // FormatDpkgControlFile outputs a debian control file, with some commong fields in
 // a sensible order
 func FormatDpkgControlFile(ctrlWriter io.Writer, paragraphs ControlFile) {
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os/exec"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/blakesmith/ar"
+)
+
+func makeTestTar(t *testing.T, files map[string]string, dirs ...string) []byte {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, d := range dirs {
+		tw.WriteHeader(&tar.Header{Name: d, Typeflag: tar.TypeDir, Mode: 0755})
+	}
+	for name, content := range files {
+		tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))})
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	return buf.Bytes()
+}
+
+func gzipTestData(data []byte) []byte {
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	gz.Write(data)
+	gz.Close()
+	return buf.Bytes()
+}
+
+func makeTestDeb(t *testing.T, dataName string, data []byte) []byte {
+	control := makeTestTar(t, map[string]string{
+		"./control": "Package: test\nVersion: 1.0-1\nArchitecture: amd64\nMaintainer: Test <test@example.com>\nDescription: test\n",
+	})
+
+	buf := &bytes.Buffer{}
+	aw := ar.NewWriter(buf)
+	aw.WriteGlobalHeader()
+	for _, f := range []struct {
+		name string
+		body []byte
+	}{
+		{"debian-binary", []byte("2.0\n")},
+		{"control.tar.gz", gzipTestData(control)},
+		{dataName, data},
+	} {
+		aw.WriteHeader(&ar.Header{Name: f.name, Size: int64(len(f.body)), Mode: 0644, ModTime: time.Now()})
+		aw.Write(f.body)
+	}
+	return buf.Bytes()
+}
+
+func TestDebPackageFiles(t *testing.T) {
+	data := makeTestTar(t, map[string]string{
+		"./usr/bin/test":                 "binary",
+		"./usr/share/doc/test/README":    "readme",
+		"./usr/share/doc/test/copyright": "copyright",
+	}, "./", "./usr/", "./usr/bin/")
+	expected := []string{"usr/bin/test", "usr/share/doc/test/README", "usr/share/doc/test/copyright"}
+
+	compressions := map[string]func() ([]byte, error){
+		"data.tar":    func() ([]byte, error) { return data, nil },
+		"data.tar.gz": func() ([]byte, error) { return gzipTestData(data), nil },
+		"data.tar.xz": func() ([]byte, error) {
+			cmd := exec.Command("xz", "-c")
+			cmd.Stdin = bytes.NewReader(data)
+			return cmd.Output()
+		},
+		"data.tar.zst": func() ([]byte, error) {
+			cmd := exec.Command("zstd", "-c")
+			cmd.Stdin = bytes.NewReader(data)
+			return cmd.Output()
+		},
+	}
+
+	for name, compress := range compressions {
+		compressed, err := compress()
+		if err != nil {
+			t.Logf("skipping %v, %v", name, err)
+			continue
+		}
+
+		deb := NewDebPackage(bytes.NewReader(makeTestDeb(t, name, compressed)), nil)
+		files, err := deb.Files()
+		if err != nil {
+			t.Errorf("%v: parsing deb failed, %v", name, err)
+			continue
+		}
+
+		var got []string
+		got = append(got, files...)
+		if len(got) != len(expected) {
+			t.Errorf("%v: expected %v, got %v", name, expected, got)
+			continue
+		}
+		seen := map[string]bool{}
+		for _, f := range got {
+			seen[f] = true
+		}
+		for _, f := range expected {
+			if !seen[f] {
+				t.Errorf("%v: expected %v, got %v", name, expected, got)
+				break
+			}
+		}
+
+		ver, _ := deb.Version()
+		if !reflect.DeepEqual(ver, MustParseDebVersion("1.0-1")) {
+			t.Errorf("%v: control data was not parsed, got version %v", name, ver)
+		}
+	}
+}
+
+func TestWriteContents(t *testing.T) {
+	a, clean, err := makeTestArchive(t)
+	if err != nil {
+		return
+	}
+	defer clean()
+
+	relid := makeTestRelease(t, a, "test", nil)
+	rel, _ := a.GetRelease(relid)
+
+	contents := make(contentsTempData)
+	contents.add([]string{"usr/bin/b", "usr/bin/a"}, "utils/pkga")
+	contents.add([]string{"usr/bin/a"}, "utils/pkgb")
+
+	c, err := rel.writeContents(contents)
+	if err != nil {
+		t.Fatalf("writing contents failed, %v", err)
+	}
+
+	rdr, _ := a.Open(c.id)
+	defer rdr.Close()
+	gz, err := gzip.NewReader(rdr)
+	if err != nil {
+		t.Fatalf("reading contents failed, %v", err)
+	}
+	buf := &bytes.Buffer{}
+	buf.ReadFrom(gz)
+
+	expected := "usr/bin/a utils/pkga,utils/pkgb\nusr/bin/b utils/pkga\n"
+	if buf.String() != expected {
+		t.Errorf("expected contents %q, got %q", expected, buf.String())
+	}
+	if c.size == 0 || c.sha256 == "" {
+		t.Errorf("contents file details not collected")
+	}
+}
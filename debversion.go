@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"strconv"
 	"strings"
 	"unicode"
@@ -168,3 +169,51 @@ func MustParseDebVersion(str string) (version DebVersion) {
 	}
 	return ver
 }
+
+// DebVersionConstraint describes a restriction on a version, as used in
+// debian package relationships. e.g. ">= 1.2-1"
+type DebVersionConstraint struct {
+	Op      string // One of <<, <=, =, >= or >>
+	Version DebVersion
+}
+
+var debVersionConstraintOps = []string{"<<", "<=", ">=", ">>", "="}
+
+// ParseDebVersionConstraint parses a version constraint. If no operator
+// is given, an exact match is assumed
+func ParseDebVersionConstraint(str string) (c DebVersionConstraint, err error) {
+	str = strings.TrimSpace(str)
+	c.Op = "="
+
+	for _, op := range debVersionConstraintOps {
+		if strings.HasPrefix(str, op) {
+			c.Op = op
+			str = strings.TrimSpace(str[len(op):])
+			break
+		}
+	}
+
+	if str == "" {
+		return c, errors.New("version constraint has no version")
+	}
+
+	c.Version, err = ParseDebVersion(str)
+	return
+}
+
+// Match returns true if the version satisfies the constraint
+func (c DebVersionConstraint) Match(v DebVersion) bool {
+	res := DebVersionCompare(v, c.Version)
+	switch c.Op {
+	case "<<":
+		return res < 0
+	case "<=":
+		return res <= 0
+	case ">=":
+		return res >= 0
+	case ">>":
+		return res > 0
+	default:
+		return res == 0
+	}
+}
@@ -105,3 +105,35 @@ func TestDebVersionString(t *testing.T) {
 		}
 	}
 }
+
+var testDebVersionConstraint = []struct {
+	constraint string
+	version    string
+	match      bool
+}{
+	{"1.0-1", "1.0-1", true},
+	{"= 1.0-1", "1.0-2", false},
+	{">= 1.0-1", "1.0-1", true},
+	{">=1.0-1", "1.0~rc1-1", false},
+	{">> 1.0-1", "1:0.1-1", true},
+	{"<< 1.0", "1.0", false},
+	{"<= 1.0", "0.9", true},
+}
+
+func TestDebVersionConstraint(t *testing.T) {
+	for i, tt := range testDebVersionConstraint {
+		c, err := ParseDebVersionConstraint(tt.constraint)
+		if err != nil {
+			t.Errorf("%d. failed to parse %s, %v\n", i, tt.constraint, err)
+			continue
+		}
+
+		if c.Match(MustParseDebVersion(tt.version)) != tt.match {
+			t.Errorf("%d. failed: %s %s expected %v\n", i, tt.constraint, tt.version, tt.match)
+		}
+	}
+
+	if _, err := ParseDebVersionConstraint(">="); err == nil {
+		t.Errorf("constraint without a version should not parse")
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+)
+
+func httpDiffHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	switch r.Method {
+	case "GET":
+		return handleWithReadLock(doHTTPDiffGetHandler, ctx, w, r)
+	default:
+		return sendResponse(w, http.StatusMethodNotAllowed, nil)
+	}
+}
+
+// Compare two releases. By default the current release of the distribution
+// is compared with its parent. The releases to compare can be given by id,
+// or the current release of another distribution can be compared with
+// this one. Release ids must be from the history of the distribution.
+func doHTTPDiffGetHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	vars := mux.Vars(r)
+	name := vars["name"]
+	query := r.URL.Query()
+
+	if query.Get("from") != "" && query.Get("dist") != "" {
+		return sendResponse(w, http.StatusBadRequest, "only one of from and dist may be given")
+	}
+
+	rel, err := state.Archive.GetDist(name)
+	switch {
+	case err == nil:
+	case os.IsNotExist(err):
+		return sendResponse(w, http.StatusNotFound, nil)
+	default:
+		return &appError{Error: fmt.Errorf("failed to retrieve store reference, %v", err)}
+	}
+
+	toid := state.Archive.Dists()[name]
+	fromid := rel.ParentID
+
+	if toStr := query.Get("to"); toStr != "" {
+		toid, err = StoreIDFromString(toStr)
+		if err != nil || len(toid) == 0 {
+			return sendResponse(w, http.StatusBadRequest, "a valid to release id must be given")
+		}
+		found, err := state.Archive.DistHasRelease(name, toid)
+		if err != nil {
+			return &appError{Error: fmt.Errorf("failed to check release history, %v", err)}
+		}
+		if !found {
+			return sendResponse(w, http.StatusBadRequest, "release is not from this distribution")
+		}
+		to, err := state.Archive.GetRelease(toid)
+		if err != nil {
+			return sendResponse(w, http.StatusNotFound, "release not found")
+		}
+		fromid = to.ParentID
+	}
+
+	if fromStr := query.Get("from"); fromStr != "" {
+		fromid, err = StoreIDFromString(fromStr)
+		if err != nil || len(fromid) == 0 {
+			return sendResponse(w, http.StatusBadRequest, "a valid from release id must be given")
+		}
+		found, err := state.Archive.DistHasRelease(name, fromid)
+		if err != nil {
+			return &appError{Error: fmt.Errorf("failed to check release history, %v", err)}
+		}
+		if !found {
+			return sendResponse(w, http.StatusBadRequest, "release is not from this distribution")
+		}
+	}
+
+	if dist := query.Get("dist"); dist != "" {
+		var ok bool
+		fromid, ok = state.Archive.Dists()[dist]
+		if !ok {
+			return sendResponse(w, http.StatusNotFound, fmt.Sprintf("distribution %v not found", dist))
+		}
+	}
+
+	if fromid.String() == state.Archive.EmptyFileID().String() {
+		return sendResponse(w, http.StatusBadRequest, "release has no parent to compare with")
+	}
+
+	if _, err = state.Archive.GetRelease(fromid); err != nil {
+		return sendResponse(w, http.StatusNotFound, "release not found")
+	}
+
+	diff, err := DiffReleases(state.Archive, fromid, toid)
+	if err != nil {
+		return &appError{Error: fmt.Errorf("failed to compare releases, %v", err)}
+	}
+
+	return sendOKResponse(w, diff)
+}
@@ -57,6 +57,10 @@ func doHTTPDistsPutHandler(ctx context.Context, w http.ResponseWriter, r *http.R
 		return sendResponse(w, http.StatusConflict, "cannot update release directly")
 	}
 
+	if from := r.URL.Query().Get("from"); from != "" {
+		return doHTTPDistsForkHandler(ctx, w, r, from)
+	}
+
 	seedrel := Release{
 		Suite:    name,
 		CodeName: name,
@@ -87,6 +91,42 @@ func doHTTPDistsPutHandler(ctx context.Context, w http.ResponseWriter, r *http.R
 	return sendOKResponse(w, rel)
 }
 
+// Create a new distribution from a release of an existing one
+func doHTTPDistsForkHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, from string) *appError {
+	vars := mux.Vars(r)
+	name, _ := vars["name"]
+
+	var id StoreID
+	if idStr := r.URL.Query().Get("id"); idStr != "" {
+		var err error
+		id, err = StoreIDFromString(idStr)
+		if err != nil || len(id) == 0 {
+			return sendResponse(w, http.StatusBadRequest, "a valid release id must be given")
+		}
+	}
+
+	_, err := state.Archive.GetDist(from)
+	switch {
+	case err == nil:
+	case os.IsNotExist(err):
+		return sendResponse(w, http.StatusNotFound, fmt.Sprintf("distribution %v not found", from))
+	default:
+		return &appError{Error: err}
+	}
+
+	res, err := updateWithGenHooks(name, func() error {
+		return state.Archive.ForkDist(name, from, id)
+	})
+	switch {
+	case err == nil:
+		return sendOKResponse(w, res)
+	case err == ErrReleaseNotFound:
+		return sendResponse(w, http.StatusNotFound, err.Error())
+	default:
+		return &appError{Error: fmt.Errorf("Forking distribution failed, %v", err)}
+	}
+}
+
 func doHTTPDistsDeleteHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) *appError {
 	if !AuthorisedAdmin(ctx, w, r) {
 		return sendResponse(w, http.StatusUnauthorized, nil)
@@ -111,3 +151,84 @@ func doHTTPDistsDeleteHandler(ctx context.Context, w http.ResponseWriter, r *htt
 
 	return nil
 }
+
+// This build a function to roll a distribution back to an earlier release
+func httpDistsRollbackHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	switch r.Method {
+	case "POST":
+		return handleWithWriteLock(doHTTPDistsRollbackHandler, ctx, w, r)
+	default:
+		return sendResponse(w, http.StatusMethodNotAllowed, nil)
+	}
+}
+
+func doHTTPDistsRollbackHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	if !AuthorisedAdmin(ctx, w, r) {
+		return sendResponse(w, http.StatusUnauthorized, nil)
+	}
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	id, err := StoreIDFromString(r.URL.Query().Get("id"))
+	if err != nil || len(id) == 0 {
+		return sendResponse(w, http.StatusBadRequest, "a valid release id must be given")
+	}
+
+	_, err = state.Archive.GetDist(name)
+	switch {
+	case err == nil:
+	case os.IsNotExist(err):
+		return sendResponse(w, http.StatusNotFound, nil)
+	default:
+		return &appError{Error: err}
+	}
+
+	res, err := updateWithGenHooks(name, func() error {
+		return state.Archive.RollbackDist(name, id, r.RemoteAddr)
+	})
+	switch {
+	case err == nil:
+		return sendOKResponse(w, res)
+	case err == ErrReleaseNotFound:
+		return sendResponse(w, http.StatusNotFound, err.Error())
+	default:
+		return &appError{Error: fmt.Errorf("failed to roll back distribution, %v", err)}
+	}
+}
+
+// This build a function to republish a distribution, rebuilding its
+// whole pool
+func httpDistsRepairHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	switch r.Method {
+	case "POST":
+		return handleWithWriteLock(doHTTPDistsRepairHandler, ctx, w, r)
+	default:
+		return sendResponse(w, http.StatusMethodNotAllowed, nil)
+	}
+}
+
+func doHTTPDistsRepairHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	if !AuthorisedAdmin(ctx, w, r) {
+		return sendResponse(w, http.StatusUnauthorized, nil)
+	}
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	_, err := state.Archive.GetDist(name)
+	switch {
+	case err == nil:
+	case os.IsNotExist(err):
+		return sendResponse(w, http.StatusNotFound, nil)
+	default:
+		return &appError{Error: err}
+	}
+
+	res, err := updateWithGenHooks(name, func() error {
+		return state.Archive.RepairDist(name)
+	})
+	if err != nil {
+		return &appError{Error: fmt.Errorf("failed to repair distribution, %v", err)}
+	}
+
+	return sendOKResponse(w, res)
+}
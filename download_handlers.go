@@ -7,10 +7,18 @@ import (
 	"golang.org/x/net/context"
 )
 
-// Construct the download handler for normal client downloads
-func makeHTTPDownloadHandler() appHandler {
+// Construct the download handler for normal client downloads. The files
+// are served from the public directory, or directly from the store if
+// fromStore is set
+func makeHTTPDownloadHandler(fromStore bool) appHandler {
+	fileHandler := http.FileServer(http.Dir(state.Archive.PublicDir()))
+	if fromStore {
+		fileHandler = state.Archive.StoreHandler()
+	}
+	fileHandler = http.StripPrefix("/repo/", fileHandler)
+
 	fsHandler := func(ctx context.Context, w http.ResponseWriter, r *http.Request) *appError {
-		http.StripPrefix("/repo/", http.FileServer(http.Dir(state.Archive.PublicDir()))).ServeHTTP(w, r)
+		fileHandler.ServeHTTP(w, r)
 		return nil
 	}
 	downloadHandler := func(ctx context.Context, w http.ResponseWriter, r *http.Request) *appError {
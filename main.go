@@ -96,6 +96,10 @@ func main() {
 					Name:  "default-verify-debs",
 					Usage: "Verify signatures on deb files",
 				},
+				cli.BoolTFlag{
+					Name:  "default-verify-dscs",
+					Usage: "Verify signatures on dsc files",
+				},
 				cli.StringFlag{
 					Name:  "default-prune",
 					Value: ".*_*-*",
@@ -108,7 +112,54 @@ func main() {
 				cli.IntFlag{
 					Name:  "default-auto-trim-length",
 					Value: 10,
-					Usage: "Rules for package pruning",
+					Usage: "Number of releases of branch history to retain (0 for no limit if an age is given)",
+				},
+				cli.StringFlag{
+					Name:  "default-auto-trim-age",
+					Value: "",
+					Usage: "Trim branch history older than this age (e.g. 90d)",
+				},
+				cli.StringFlag{
+					Name:  "default-index-compressions",
+					Value: "",
+					Usage: "Additional compressions of the package indexes (xz, bz2)",
+				},
+				cli.IntFlag{
+					Name:  "default-by-hash-generations",
+					Value: 3,
+					Usage: "Number of previous releases to keep by-hash indexes for",
+				},
+				cli.BoolFlag{
+					Name:  "serve-from-store",
+					Usage: "Serve the repository directly from the store, rather than publishing it to the archive directory",
+				},
+				cli.DurationFlag{
+					Name:  "publish-grace",
+					Value: DefaultPublishGrace,
+					Usage: "How long to keep the previously published files of a distribution",
+				},
+				cli.DurationFlag{
+					Name:  "refresh-interval",
+					Value: time.Hour,
+					Usage: "How often to check for release files that are due to expire",
+				},
+				cli.StringFlag{
+					Name:  "default-components",
+					Value: "main",
+					Usage: "Components packages may be uploaded to",
+				},
+				cli.StringFlag{
+					Name:  "default-architectures",
+					Value: "",
+					Usage: "Architectures packages may be uploaded for, any if empty (e.g. amd64,i386)",
+				},
+				cli.BoolFlag{
+					Name:  "default-separate-arch-all",
+					Usage: "Only list Architecture: all packages in binary-all, rather than in every architecture",
+				},
+				cli.BoolFlag{
+					Name:  "default-strip-long-descriptions",
+					Usage: "Only publish long descriptions in the Translation-en indexes",
 				},
 			},
 			Usage:  "run a repository server",
@@ -122,10 +173,37 @@ func main() {
 					Value: "http://localhost:3000/dists/master/upload",
 					Usage: "URL to upload to",
 				},
+				cli.StringFlag{
+					Name:  "component",
+					Value: "",
+					Usage: "Component to upload to, overriding the package sections",
+				},
 			},
 			Usage:  "publish a package to a repository",
 			Action: CmdUpload,
 		},
+		cli.Command{
+			Name: "promote",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "url",
+					Value: "http://localhost:3000",
+					Usage: "Base URL of the repository server",
+				},
+				cli.StringFlag{
+					Name:  "from",
+					Value: "",
+					Usage: "Distribution to copy the package from",
+				},
+				cli.StringFlag{
+					Name:  "to",
+					Value: "",
+					Usage: "Distribution to copy the package to",
+				},
+			},
+			Usage:  "copy a package from one distribution to another",
+			Action: CmdPromote,
+		},
 	}
 
 	app.Run(os.Args)
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+)
+
+// This build a function to manage the packages in a distribution
+func httpPackagesHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	switch r.Method {
+	case "GET":
+		return handleWithReadLock(doHTTPPackagesGetHandler, ctx, w, r)
+	case "PUT":
+		return handleWithWriteLock(doHTTPPackagesPutHandler, ctx, w, r)
+	case "DELETE":
+		return handleWithWriteLock(doHTTPPackagesDeleteHandler, ctx, w, r)
+	default:
+		return sendResponse(w, http.StatusMethodNotAllowed, nil)
+	}
+}
+
+// packageQueryResult is a page of the results of a package query
+type packageQueryResult struct {
+	Total    int
+	Offset   int
+	Limit    int
+	Packages []PackageInfo
+}
+
+// The default and maximum number of packages returned by a query
+const (
+	packageQueryDefaultLimit = 100
+	packageQueryMaxLimit     = 1000
+)
+
+// List the packages in a distribution, filtered by the query parameters
+func doHTTPPackagesGetHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	vars := mux.Vars(r)
+	name := vars["name"]
+	vals := r.URL.Query()
+
+	if _, ok := vars["source"]; ok {
+		return doHTTPPackageDetailHandler(ctx, w, r)
+	}
+
+	rel, err := state.Archive.GetDist(name)
+	switch {
+	case err == nil:
+	case os.IsNotExist(err):
+		return sendResponse(w, http.StatusNotFound, nil)
+	default:
+		return &appError{Error: err}
+	}
+
+	q, err := ParsePackageQuery(vals)
+	if err != nil {
+		return sendResponse(w, http.StatusBadRequest, err.Error())
+	}
+
+	res := packageQueryResult{
+		Limit:    packageQueryDefaultLimit,
+		Packages: []PackageInfo{},
+	}
+
+	if offStr := vals.Get("offset"); offStr != "" {
+		res.Offset, err = strconv.Atoi(offStr)
+		if err != nil || res.Offset < 0 {
+			return sendResponse(w, http.StatusBadRequest, "invalid offset")
+		}
+	}
+
+	if limStr := vals.Get("limit"); limStr != "" {
+		res.Limit, err = strconv.Atoi(limStr)
+		if err != nil || res.Limit < 1 || res.Limit > packageQueryMaxLimit {
+			return sendResponse(w, http.StatusBadRequest,
+				fmt.Sprintf("limit must be between 1 and %d", packageQueryMaxLimit))
+		}
+	}
+
+	index, err := state.Archive.OpenReleaseIndex(rel.IndexID)
+	if err != nil {
+		return &appError{Error: fmt.Errorf("failed to open release index, %v", err)}
+	}
+	defer index.Close()
+
+	addItem := func(e *ReleaseIndexEntry, item *ReleaseIndexEntryItem) error {
+		if !q.Match(item) {
+			return nil
+		}
+
+		res.Total++
+		if res.Total <= res.Offset || len(res.Packages) >= res.Limit {
+			return nil
+		}
+
+		var ctrl *ControlParagraph
+		if len(item.ControlID) != 0 {
+			ctrlFile, err := state.Archive.GetControlFile(item.ControlID)
+			if err != nil {
+				return err
+			}
+			if len(ctrlFile.Data) > 0 {
+				ctrl = ctrlFile.Data[0]
+			}
+		}
+
+		res.Packages = append(res.Packages, NewPackageInfo(e, item, ctrl))
+		return nil
+	}
+
+	for {
+		e, err := index.NextEntry()
+		if err != nil {
+			break
+		}
+
+		if len(e.SourceItem.Files) != 0 {
+			if err = addItem(&e, &e.SourceItem); err != nil {
+				return &appError{Error: fmt.Errorf("failed to retrieve control data, %v", err)}
+			}
+		}
+
+		for i := range e.BinaryItems {
+			if err = addItem(&e, &e.BinaryItems[i]); err != nil {
+				return &appError{Error: fmt.Errorf("failed to retrieve control data, %v", err)}
+			}
+		}
+	}
+
+	return sendOKResponse(w, res)
+}
+
+// packageFileDetail describes one of the files making up a package
+type packageFileDetail struct {
+	Name     string
+	StoreID  StoreID
+	Size     int64
+	Md5      string   `json:",omitempty"`
+	Sha1     string   `json:",omitempty"`
+	Sha256   string   `json:",omitempty"`
+	SignedBy []string `json:",omitempty"`
+}
+
+// packageItemDetail describes a source or binary item of a package
+type packageItemDetail struct {
+	PackageInfo
+	Files []packageFileDetail
+}
+
+// packageDetail is the full description of a package in a distribution
+type packageDetail struct {
+	Source         string
+	Version        string
+	FirstRelease   StoreID
+	RemovedRelease StoreID            `json:",omitempty"`
+	Changes        string             `json:",omitempty"`
+	SourceItem     *packageItemDetail `json:",omitempty"`
+	BinaryItems    []packageItemDetail
+}
+
+func newPackageItemDetail(e *ReleaseIndexEntry, item *ReleaseIndexEntryItem) (*packageItemDetail, error) {
+	var ctrl *ControlParagraph
+	if len(item.ControlID) != 0 {
+		ctrlFile, err := state.Archive.GetControlFile(item.ControlID)
+		if err != nil {
+			return nil, err
+		}
+		if len(ctrlFile.Data) > 0 {
+			ctrl = ctrlFile.Data[0]
+		}
+	}
+
+	res := &packageItemDetail{
+		PackageInfo: NewPackageInfo(e, item, ctrl),
+		Files:       []packageFileDetail{},
+	}
+
+	for _, f := range item.Files {
+		res.Files = append(res.Files, packageFileDetail{
+			Name:     f.Name,
+			StoreID:  f.StoreID,
+			Size:     f.Size,
+			Md5:      hex.EncodeToString(f.Md5),
+			Sha1:     hex.EncodeToString(f.Sha1),
+			Sha256:   hex.EncodeToString(f.Sha256),
+			SignedBy: f.SignedBy,
+		})
+	}
+
+	return res, nil
+}
+
+// Describe a single package, including its history within the distribution
+func doHTTPPackageDetailHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	vars := mux.Vars(r)
+	name := vars["name"]
+	source := vars["source"]
+
+	version, err := ParseDebVersion(vars["version"])
+	if err != nil {
+		return sendResponse(w, http.StatusBadRequest, "invalid version, "+err.Error())
+	}
+
+	_, err = state.Archive.GetDist(name)
+	switch {
+	case err == nil:
+	case os.IsNotExist(err):
+		return sendResponse(w, http.StatusNotFound, nil)
+	default:
+		return &appError{Error: err}
+	}
+
+	hist, err := state.Archive.PackageHistory(name, source, version)
+	switch {
+	case err == nil:
+	case err == ErrPackageNotFound:
+		return sendResponse(w, http.StatusNotFound, nil)
+	default:
+		return &appError{Error: fmt.Errorf("failed to retrieve package history, %v", err)}
+	}
+
+	e := hist.Entry
+	res := packageDetail{
+		Source:         e.SourceItem.Name,
+		Version:        e.SourceItem.Version.String(),
+		FirstRelease:   hist.FirstRelease,
+		RemovedRelease: hist.RemovedRelease,
+		BinaryItems:    []packageItemDetail{},
+	}
+
+	if len(e.ChangesID) != 0 {
+		rdr, err := state.Archive.Open(e.ChangesID)
+		if err == nil {
+			changes, err := ioutil.ReadAll(rdr)
+			rdr.Close()
+			if err != nil {
+				return &appError{Error: fmt.Errorf("failed to read changes file, %v", err)}
+			}
+			res.Changes = string(changes)
+		}
+	}
+
+	if len(e.SourceItem.Files) != 0 {
+		res.SourceItem, err = newPackageItemDetail(e, &e.SourceItem)
+		if err != nil {
+			return &appError{Error: fmt.Errorf("failed to retrieve control data, %v", err)}
+		}
+	}
+
+	for i := range e.BinaryItems {
+		item, err := newPackageItemDetail(e, &e.BinaryItems[i])
+		if err != nil {
+			return &appError{Error: fmt.Errorf("failed to retrieve control data, %v", err)}
+		}
+		res.BinaryItems = append(res.BinaryItems, *item)
+	}
+
+	return sendOKResponse(w, res)
+}
+
+// Remove a package, or one architecture of a package, from a distribution
+func doHTTPPackagesDeleteHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	if !AuthorisedAdmin(ctx, w, r) {
+		return sendResponse(w, http.StatusUnauthorized, nil)
+	}
+
+	vars := mux.Vars(r)
+	name := vars["name"]
+	source := vars["source"]
+	arch := r.URL.Query().Get("arch")
+
+	version, err := ParseDebVersion(vars["version"])
+	if err != nil {
+		return sendResponse(w, http.StatusBadRequest, "invalid version, "+err.Error())
+	}
+
+	_, err = state.Archive.GetDist(name)
+	switch {
+	case err == nil:
+	case os.IsNotExist(err):
+		return sendResponse(w, http.StatusNotFound, nil)
+	default:
+		return &appError{Error: err}
+	}
+
+	res, err := updateWithGenHooks(name, func() error {
+		return state.Archive.RemovePackage(name, source, version, arch, r.RemoteAddr)
+	})
+	switch {
+	case err == nil:
+		return sendOKResponse(w, res)
+	case err == ErrPackageNotFound:
+		return sendResponse(w, http.StatusNotFound, nil)
+	default:
+		return &appError{Error: fmt.Errorf("failed to remove package, %v", err)}
+	}
+}
+
+// Copy a package from another distribution into this one
+func doHTTPPackagesPutHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	if !AuthorisedAdmin(ctx, w, r) {
+		return sendResponse(w, http.StatusUnauthorized, nil)
+	}
+
+	vars := mux.Vars(r)
+	name := vars["name"]
+	source := vars["source"]
+	from := r.URL.Query().Get("from")
+
+	if from == "" {
+		return sendResponse(w, http.StatusBadRequest, "from parameter must be given")
+	}
+	if from == name {
+		return sendResponse(w, http.StatusBadRequest, "cannot promote a package to the same distribution")
+	}
+
+	version, err := ParseDebVersion(vars["version"])
+	if err != nil {
+		return sendResponse(w, http.StatusBadRequest, "invalid version, "+err.Error())
+	}
+
+	for _, dist := range []string{name, from} {
+		_, err = state.Archive.GetDist(dist)
+		switch {
+		case err == nil:
+		case os.IsNotExist(err):
+			return sendResponse(w, http.StatusNotFound, fmt.Sprintf("distribution %v not found", dist))
+		default:
+			return &appError{Error: err}
+		}
+	}
+
+	res, err := updateWithGenHooks(name, func() error {
+		return state.Archive.PromotePackage(from, name, source, version)
+	})
+	switch {
+	case err == nil:
+		return sendOKResponse(w, res)
+	case err == ErrPackageNotFound:
+		return sendResponse(w, http.StatusNotFound, nil)
+	default:
+		if _, ok := err.(NotAllowedError); ok {
+			return sendResponse(w, http.StatusBadRequest, err.Error())
+		}
+		return &appError{Error: fmt.Errorf("failed to promote package, %v", err)}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// PackageQuery describes a filter for the items in a release index
+type PackageQuery struct {
+	NamePattern  *regexp.Regexp
+	Architecture string
+	Component    string
+	Versions     []DebVersionConstraint
+}
+
+// ParsePackageQuery builds a package query from the parameters in a URL
+// query string
+func ParsePackageQuery(vals url.Values) (*PackageQuery, error) {
+	var q PackageQuery
+	var err error
+
+	if name := vals.Get("name"); name != "" {
+		q.NamePattern, err = regexp.Compile("^(" + name + ")$")
+		if err != nil {
+			return nil, errors.New("invalid name pattern, " + err.Error())
+		}
+	}
+
+	q.Architecture = vals.Get("arch")
+	q.Component = vals.Get("component")
+
+	for _, v := range vals["version"] {
+		c, err := ParseDebVersionConstraint(v)
+		if err != nil {
+			return nil, errors.New("invalid version constraint, " + err.Error())
+		}
+		q.Versions = append(q.Versions, c)
+	}
+
+	return &q, nil
+}
+
+// Match returns true if the item matches all the criteria of the query
+func (q *PackageQuery) Match(item *ReleaseIndexEntryItem) bool {
+	if q.NamePattern != nil && !q.NamePattern.MatchString(item.Name) {
+		return false
+	}
+
+	if q.Architecture != "" && q.Architecture != item.Architecture {
+		return false
+	}
+
+	if q.Component != "" && q.Component != item.Component {
+		return false
+	}
+
+	for _, c := range q.Versions {
+		if !c.Match(item.Version) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// PackageInfo describes a single source or binary item in a release
+type PackageInfo struct {
+	Source       string
+	Name         string
+	Version      string
+	Architecture string
+	Component    string
+	Control      map[string]string `json:",omitempty"`
+}
+
+// NewPackageInfo describes an item from the given entry. If the control
+// data for the item is given it is included
+func NewPackageInfo(e *ReleaseIndexEntry, item *ReleaseIndexEntryItem, ctrl *ControlParagraph) PackageInfo {
+	info := PackageInfo{
+		Source:       e.SourceItem.Name,
+		Name:         item.Name,
+		Version:      item.Version.String(),
+		Architecture: item.Architecture,
+		Component:    item.Component,
+	}
+
+	if ctrl != nil {
+		info.Control = make(map[string]string)
+		for k, vs := range *ctrl {
+			lines := make([]string, len(vs))
+			for i := range vs {
+				lines[i] = *vs[i]
+			}
+			info.Control[k] = strings.Join(lines, "\n")
+		}
+	}
+
+	return info
+}
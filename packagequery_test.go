@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+var testPackageQueryItems = []ReleaseIndexEntryItem{
+	{Name: "pkga", Version: DebVersion{0, "1", "1"}, Architecture: "amd64", Component: "main"},
+	{Name: "pkga", Version: DebVersion{0, "2", "1"}, Architecture: "i386", Component: "main"},
+	{Name: "libpkga", Version: DebVersion{0, "2", "1"}, Architecture: "all", Component: "contrib"},
+	{Name: "pkga", Version: DebVersion{0, "2", "1"}, Architecture: "source", Component: "main"},
+}
+
+var testPackageQuery = []struct {
+	query   string
+	matches []bool
+}{
+	{"", []bool{true, true, true, true}},
+	{"name=pkga", []bool{true, true, false, true}},
+	{"name=.*pkga", []bool{true, true, true, true}},
+	{"arch=i386", []bool{false, true, false, false}},
+	{"component=contrib", []bool{false, false, true, false}},
+	{"version=>>1-1", []bool{false, true, true, true}},
+	{"version=>>1-1&version=<<2-1", []bool{false, false, false, false}},
+	{"name=pkga&arch=source&version=2-1", []bool{false, false, false, true}},
+}
+
+func TestPackageQuery(t *testing.T) {
+	for i, tt := range testPackageQuery {
+		vals, _ := url.ParseQuery(tt.query)
+		q, err := ParsePackageQuery(vals)
+		if err != nil {
+			t.Errorf("%d. failed to parse %v, %v", i, tt.query, err)
+			continue
+		}
+
+		for j := range testPackageQueryItems {
+			if q.Match(&testPackageQueryItems[j]) != tt.matches[j] {
+				t.Errorf("%d. %v, item %d expected %v", i, tt.query, j, tt.matches[j])
+			}
+		}
+	}
+}
+
+func TestPackageQueryInvalid(t *testing.T) {
+	for _, str := range []string{"name=(", "version=>>"} {
+		vals, _ := url.ParseQuery(str)
+		if _, err := ParsePackageQuery(vals); err == nil {
+			t.Errorf("query %v should not parse", str)
+		}
+	}
+}
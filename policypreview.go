@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// PolicyPreviewEntry describes an index entry that would be pruned
+type PolicyPreviewEntry struct {
+	Source        string
+	Version       string
+	Architectures []string
+}
+
+// PolicyPreviewRelease describes a release whose assets would no
+// longer be retained after trimming
+type PolicyPreviewRelease struct {
+	ID      StoreID
+	Version string
+	Date    time.Time
+}
+
+// PolicyPreview describes the effect that applying a set of prune
+// and trim settings to a distribution would have
+type PolicyPreview struct {
+	Release   StoreID
+	Pruned    []PolicyPreviewEntry
+	Trimmed   []PolicyPreviewRelease
+	TrimAfter int32
+}
+
+// PreviewPolicy runs the pruner and trimmer described by cfg over the
+// index and history of the given release. No objects are written to
+// the store.
+func PreviewPolicy(store Archiver, head *Release, cfg *ReleaseConfig) (*PolicyPreview, error) {
+	preview := &PolicyPreview{
+		Release: head.id,
+		Pruned:  []PolicyPreviewEntry{},
+		Trimmed: []PolicyPreviewRelease{},
+	}
+
+	idx, err := store.OpenReleaseIndex(head.IndexID)
+	if err != nil {
+		return nil, errors.New("error opening release index, " + err.Error())
+	}
+	defer idx.Close()
+
+	pruner := cfg.MakePruner()
+	for {
+		entry, err := idx.NextEntry()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.New("error reading release index, " + err.Error())
+		}
+
+		if !pruner(&entry) {
+			continue
+		}
+
+		pe := PolicyPreviewEntry{
+			Source:  entry.SourceItem.Name,
+			Version: entry.SourceItem.Version.String(),
+		}
+		if len(entry.SourceItem.Files) > 0 {
+			pe.Architectures = append(pe.Architectures, "source")
+		}
+		for _, b := range entry.BinaryItems {
+			pe.Architectures = append(pe.Architectures, b.Architecture)
+		}
+		preview.Pruned = append(preview.Pruned, pe)
+	}
+
+	if !cfg.AutoTrim {
+		return preview, nil
+	}
+
+	// Trim a hypothetical child of the current release
+	child := head.NewChild()
+	err = child.TrimHistory(store, cfg.MakeTrimmer())
+	if err != nil {
+		return nil, err
+	}
+
+	preview.TrimAfter = child.TrimAfter
+	if child.TrimAfter == 0 {
+		return preview, nil
+	}
+
+	retained, err := retainedHistory(store, head)
+	if err != nil {
+		return nil, err
+	}
+
+	if int(child.TrimAfter) < len(retained) {
+		for _, rel := range retained[child.TrimAfter:] {
+			preview.Trimmed = append(preview.Trimmed, PolicyPreviewRelease{
+				ID:      rel.id,
+				Version: rel.Version,
+				Date:    rel.Date,
+			})
+		}
+	}
+
+	return preview, nil
+}
+
+// retainedHistory returns the releases, starting at head, whose assets
+// are currently retained by the garbage collector
+func retainedHistory(store Archiver, head *Release) ([]*Release, error) {
+	var retained []*Release
+	trimmerActive := false
+	trimAfter := int32(0)
+
+	curr := head
+	for {
+		if trimmerActive {
+			if trimAfter == 0 {
+				break
+			}
+			trimAfter--
+		}
+
+		retained = append(retained, curr)
+
+		if curr.ParentID.String() == store.EmptyFileID().String() {
+			break
+		}
+
+		if curr.TrimAfter > 0 && !trimmerActive {
+			trimAfter = curr.TrimAfter
+			trimmerActive = true
+		}
+
+		parent, err := store.GetRelease(curr.ParentID)
+		if err != nil {
+			return nil, errors.New("error reading release history, " + err.Error())
+		}
+		curr = parent
+	}
+
+	return retained, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestPreviewPolicy(t *testing.T) {
+	a, clean, err := makeTestArchive(t)
+	if err != nil {
+		return
+	}
+	defer clean()
+
+	var ids []StoreID
+	id := makeTestRelease(t, a, "test", testRemoveInput)
+	for i := 0; i < 3; i++ {
+		ids = append(ids, id)
+		rel, _ := a.GetRelease(id)
+		id, err = a.AddRelease(rel.NewChild())
+		if err != nil {
+			t.Fatalf("creating release failed, %v", err)
+		}
+	}
+
+	head, _ := a.GetRelease(id)
+	cfg := *head.Config()
+	cfg.PruneRules = ".*_0-0"
+
+	preview, err := PreviewPolicy(a, head, &cfg)
+	if err != nil {
+		t.Fatalf("preview failed, %v", err)
+	}
+
+	if len(preview.Pruned) != 1 ||
+		preview.Pruned[0].Source != "pkga" ||
+		preview.Pruned[0].Version != "1-1" ||
+		len(preview.Pruned[0].Architectures) != 1 ||
+		preview.Pruned[0].Architectures[0] != "amd64" {
+		t.Errorf("unexpected pruned entries, %v", preview.Pruned)
+	}
+	if len(preview.Trimmed) != 0 {
+		t.Errorf("nothing should be trimmed when AutoTrim is disabled, got %v", preview.Trimmed)
+	}
+
+	var bad policyUpdate
+	json.Unmarshal([]byte(`{"AutoTrim": true, "AutoTrimAge": "-36h"}`), &bad)
+	badCfg := cfg
+	if _, err = bad.apply(&badCfg); err == nil {
+		t.Errorf("negative trim age should be rejected")
+	}
+
+	bad = policyUpdate{}
+	json.Unmarshal([]byte(`{"AutoTrim": true, "AutoTrimLength": -1}`), &bad)
+	badCfg = cfg
+	if _, err = bad.apply(&badCfg); err == nil {
+		t.Errorf("negative trim length should be rejected")
+	}
+
+	var d policyUpdate
+	json.Unmarshal([]byte(`{"AutoTrim": true, "AutoTrimLength": 1}`), &d)
+	acts, err := d.apply(&cfg)
+	if err != nil || len(acts) != 2 {
+		t.Fatalf("applying policy update failed, %v %v", acts, err)
+	}
+	preview, err = PreviewPolicy(a, head, &cfg)
+	if err != nil {
+		t.Fatalf("preview failed, %v", err)
+	}
+
+	if preview.TrimAfter != 2 {
+		t.Errorf("expected history to be trimmed after 2 releases, got %v", preview.TrimAfter)
+	}
+	// The two oldest releases and the release root are no longer retained
+	if len(preview.Trimmed) != 3 ||
+		preview.Trimmed[0].ID.String() != ids[1].String() ||
+		preview.Trimmed[1].ID.String() != ids[0].String() {
+		t.Errorf("unexpected trimmed releases, %v", preview.Trimmed)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// poolUpdate describes the changes made to the pool of a distribution
+type poolUpdate struct {
+	files   map[string]StoreID // The files in the pool, by path relative to the base
+	removed []string           // Files no longer used, which have not yet been removed
+}
+
+// poolFiles lists the files in the pool of a release, by their path
+// relative to the base of the archive
+func (a *archiveStoreArchive) poolFiles(release *Release) (map[string]StoreID, error) {
+	index, err := a.OpenReleaseIndex(release.IndexID)
+	if err != nil {
+		return nil, err
+	}
+	defer index.Close()
+
+	files := make(map[string]StoreID)
+	for {
+		e, err := index.NextEntry()
+		if err != nil {
+			if err != io.EOF {
+				return nil, err
+			}
+			break
+		}
+
+		srcName := e.SourceItem.Name
+		srcVersion := e.SourceItem.Version.String()
+		poolpath := fmt.Sprintf("%s%s/%s/",
+			release.PoolFilePath(srcName),
+			srcName,
+			srcVersion,
+		)
+
+		files[poolpath+fmt.Sprintf("%s_%s.changes", srcName, srcVersion)] = e.ChangesID
+
+		for _, s := range e.SourceItem.Files {
+			files[poolpath+s.Name] = s.StoreID
+		}
+
+		for _, b := range e.BinaryItems {
+			files[poolpath+b.Files[0].Name] = b.Files[0].StoreID
+		}
+	}
+
+	return files, nil
+}
+
+// previousPoolFiles returns the pool files of the parent of a release, if
+// the parent is the currently published release. ok is false if the pool
+// must be rebuilt in full
+func (a *archiveStoreArchive) previousPoolFiles(base string, release *Release) (files map[string]StoreID, ok bool) {
+	info, err := os.Lstat(base + "/pool/" + release.CodeName)
+	if err != nil || !info.IsDir() {
+		return nil, false
+	}
+
+	gen := currentGeneration(base, release.CodeName)
+	if gen == "" || generationRelease(gen) != release.ParentID.String() {
+		return nil, false
+	}
+
+	parent, err := a.GetRelease(release.ParentID)
+	if err != nil {
+		return nil, false
+	}
+
+	files, err = a.poolFiles(parent)
+	if err != nil {
+		log.Printf("Could not list previous pool files, %v", err)
+		return nil, false
+	}
+
+	return files, true
+}
+
+// updatePool adds the files of a release to the pool in base. Only the
+// files that differ from those of the previously published release are
+// linked, unless full is set, or the previous pool is not known, in which
+// case every file is linked, and the whole pool is checked for unused
+// files. Unused files are not removed, they are listed in the update
+func (a *archiveStoreArchive) updatePool(base string, release *Release, full bool) (*poolUpdate, error) {
+	files, err := a.poolFiles(release)
+	if err != nil {
+		return nil, err
+	}
+	update := &poolUpdate{files: files}
+
+	var prev map[string]StoreID
+	if !full {
+		var ok bool
+		prev, ok = a.previousPoolFiles(base, release)
+		full = !ok
+	}
+
+	poolBase := base + "/pool/" + release.CodeName
+	if full {
+		log.Printf("Rebuilding pool %v", poolBase)
+
+		// Pools published before pools were updated incrementally
+		// were symlinks to a generation
+		if info, err := os.Lstat(poolBase); err == nil && !info.IsDir() {
+			os.Remove(poolBase)
+		}
+
+		// The pool directory must exist, even if it is empty
+		err = os.MkdirAll(poolBase, 0777)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	linked := 0
+	for p, id := range files {
+		if previd, ok := prev[p]; ok && previd.String() == id.String() {
+			continue
+		}
+
+		err = a.linkReplace(id, base+"/"+p)
+		if err != nil {
+			return nil, err
+		}
+		linked++
+	}
+
+	if full {
+		err = filepath.Walk(poolBase, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			p := strings.TrimPrefix(path, base+"/")
+			if _, ok := files[p]; !ok {
+				update.removed = append(update.removed, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		for p := range prev {
+			if _, ok := files[p]; !ok {
+				update.removed = append(update.removed, p)
+			}
+		}
+	}
+
+	log.Printf("Pool update complete, %v files linked, %v retired", linked, len(update.removed))
+	return update, nil
+}
+
+// linkReplace links a store item into place at path, atomically replacing
+// any different file already there
+func (a *archiveStoreArchive) linkReplace(id StoreID, path string) error {
+	err := a.Link(id, path)
+	if err == nil {
+		return nil
+	}
+	if _, serr := os.Lstat(path); serr != nil {
+		return err
+	}
+
+	tmp := path + ".new"
+	os.Remove(tmp)
+	err = a.Link(id, tmp)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// retirePoolFiles records pool files that are no longer used by the current
+// generation of a distribution, against the generation that last used them.
+// They are removed when that generation is removed. If there is no such
+// generation they are removed immediately
+func retirePoolFiles(base, gen string, files []string) {
+	if len(files) == 0 {
+		return
+	}
+
+	if gen == "" {
+		removePoolFiles(base, files, nil)
+		return
+	}
+
+	f, err := os.OpenFile(gen+"/retired", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		log.Printf("Could not record retired pool files, %v", err)
+		return
+	}
+	defer f.Close()
+
+	for _, p := range files {
+		fmt.Fprintln(f, p)
+	}
+}
+
+// removeRetiredPoolFiles removes the pool files retired against a
+// generation, unless they are in use again
+func removeRetiredPoolFiles(base, gen string, inUse map[string]StoreID) {
+	f, err := os.Open(gen + "/retired")
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	var files []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		files = append(files, scanner.Text())
+	}
+
+	removePoolFiles(base, files, inUse)
+}
+
+// removePoolFiles removes files from the pool, and any directories left
+// empty, skipping any that are in use
+func removePoolFiles(base string, files []string, inUse map[string]StoreID) {
+	for _, p := range files {
+		if _, ok := inUse[p]; ok || !strings.HasPrefix(p, "pool/") {
+			continue
+		}
+
+		err := os.Remove(base + "/" + p)
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("Removing pool file failed, %v", err)
+			continue
+		}
+
+		// Remove directories that are now empty, up to the pool itself
+		for dir := filepath.Dir(p); strings.Count(dir, "/") > 1; dir = filepath.Dir(dir) {
+			if os.Remove(base+"/"+dir) != nil {
+				break
+			}
+		}
+	}
+}
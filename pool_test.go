@@ -0,0 +1,124 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func makeTestPoolEntry(t *testing.T, a *archiveStoreArchive, name string) *ReleaseIndexEntry {
+	store := func(content string) StoreID {
+		id, err := a.CopyToStore(ioutil.NopCloser(strings.NewReader(content)))
+		if err != nil {
+			t.Fatalf("storing test file failed, %v", err)
+		}
+		return id
+	}
+
+	return &ReleaseIndexEntry{
+		SourceItem: ReleaseIndexEntryItem{
+			Name:         name,
+			Version:      DebVersion{0, "1", "1"},
+			Architecture: "source",
+			Component:    "main",
+			Files: []ReleaseIndexEntryItemFile{
+				{Name: name + "_1-1.dsc", StoreID: store(name + " dsc")},
+			},
+		},
+		ChangesID: store(name + " changes"),
+	}
+}
+
+func TestIncrementalPool(t *testing.T) {
+	a, clean, err := makeTestArchive(t)
+	if err != nil {
+		return
+	}
+	defer clean()
+	base := a.PublicDir()
+	a.publishGrace = time.Hour
+
+	pkga := makeTestPoolEntry(t, a, "pkga")
+	pkgb := makeTestPoolEntry(t, a, "pkgb")
+	pkgc := makeTestPoolEntry(t, a, "pkgc")
+
+	makeIndex := func(entries ...*ReleaseIndexEntry) StoreID {
+		idx, _ := a.AddReleaseIndex()
+		for _, e := range entries {
+			idx.AddEntry(e)
+		}
+		idxid, err := idx.Close()
+		if err != nil {
+			t.Fatalf("creating index failed, %v", err)
+		}
+		return idxid
+	}
+
+	rootid, err := a.GetReleaseRoot(Release{CodeName: "test", Suite: "test", Version: "0"})
+	if err != nil {
+		t.Fatalf("creating release root failed, %v", err)
+	}
+	firstid, err := NewRelease(a, rootid, makeIndex(pkga, pkgb), []ReleaseLogAction{})
+	if err != nil {
+		t.Fatalf("creating release failed, %v", err)
+	}
+	a.SetDist("test", firstid)
+	err = a.ReifyRelease(firstid)
+	if err != nil {
+		t.Fatalf("reifying release failed, %v", err)
+	}
+
+	poolFile := func(name string) string {
+		return base + "/pool/test/" + name[:1] + "/" + name + "/1-1/" + name + "_1-1.dsc"
+	}
+	exists := func(name string) bool {
+		_, err := os.Stat(poolFile(name))
+		return err == nil
+	}
+
+	if !exists("pkga") || !exists("pkgb") {
+		t.Fatalf("initial pool not populated")
+	}
+
+	// Files already in the pool should not be linked again
+	os.Remove(poolFile("pkga"))
+
+	secondid, err := NewRelease(a, firstid, makeIndex(pkga, pkgc), []ReleaseLogAction{})
+	if err != nil {
+		t.Fatalf("creating release failed, %v", err)
+	}
+	a.SetDist("test", secondid)
+
+	err = a.ReifyRelease(secondid)
+	if err != nil {
+		t.Fatalf("reifying release failed, %v", err)
+	}
+
+	if exists("pkga") {
+		t.Errorf("unchanged pool file was relinked")
+	}
+	if !exists("pkgc") {
+		t.Errorf("new pool file was not linked")
+	}
+	if !exists("pkgb") {
+		t.Errorf("removed pool file should be kept during the grace period")
+	}
+
+	a.publishGrace = 0
+	err = a.RepairDist("test")
+	if err != nil {
+		t.Fatalf("repairing dist failed, %v", err)
+	}
+
+	if !exists("pkga") {
+		t.Errorf("repair did not restore missing pool file")
+	}
+	if exists("pkgb") {
+		t.Errorf("removed pool file should be removed after the grace period")
+	}
+	if _, err := os.Stat(base + "/pool/test/p/pkgb"); !os.IsNotExist(err) {
+		t.Errorf("empty pool directories should be removed")
+	}
+}
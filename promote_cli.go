@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/codegangsta/cli"
+)
+
+// CmdPromote is the implementation of the godinstall "promote" command
+func CmdPromote(c *cli.Context) {
+	baseURL := c.String("url")
+	from := c.String("from")
+	to := c.String("to")
+
+	if from == "" || to == "" {
+		log.Println("You must pass --from and --to")
+		os.Exit(1)
+	}
+
+	if len(c.Args()) != 2 {
+		log.Println("You must pass a source package name and version")
+		os.Exit(1)
+	}
+
+	source := c.Args().Get(0)
+	version := c.Args().Get(1)
+
+	uri := baseURL + "/dists/" + url.QueryEscape(to) +
+		"/packages/" + url.QueryEscape(source) +
+		"/" + url.QueryEscape(version) +
+		"?from=" + url.QueryEscape(from)
+
+	err := cliPromotePackage(&http.Client{}, uri)
+	if err != nil {
+		log.Printf("Promotion of %s %s from %s to %s failed, %s", source, version, from, to, err.Error())
+		os.Exit(1)
+	}
+
+	log.Printf("Promoted %s %s from %s to %s", source, version, from, to)
+	os.Exit(0)
+}
+
+func cliPromotePackage(c *http.Client, uri string) error {
+	req, err := http.NewRequest("PUT", uri, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 400 {
+		var status string
+		err = json.Unmarshal(body, &status)
+		if err != nil {
+			return errors.New(string(body))
+		}
+		return errors.New(status)
+	}
+
+	return nil
+}
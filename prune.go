@@ -1,10 +1,11 @@
 package main
 
 import (
-	"errors"
+	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // PruneRule describes the details of a rule for removing old
@@ -15,128 +16,350 @@ type PruneRule struct {
 	retainVersions  int64          // how many additional historical versions should we keep
 	limitRevisions  bool           // should we limit the number of old revisions
 	retainRevisions int64          // how many additional historical revisions should we keep
+	retainNewer     time.Duration  // items uploaded within this period are always kept
+	arch            string         // only apply this rule to the given architecture
+	perArch         bool           // count versions for each architecture separately
+}
+
+// PrunePin exempts a specific version of a package from pruning
+type PrunePin struct {
+	Name    string
+	Version DebVersion
 }
 
 // PruneRuleSet is a group of pruning rules provided by the user
-type PruneRuleSet []*PruneRule
+type PruneRuleSet struct {
+	Rules []*PruneRule
+	Pins  []PrunePin
+}
+
+// PruneRuleError describes a problem with a specific token in a
+// set of prune rules
+type PruneRuleError struct {
+	Token  string // The offending token
+	Offset int    // The offset of the token in the rules string
+	Reason string
+}
+
+func (e *PruneRuleError) Error() string {
+	return fmt.Sprintf("invalid prune rule token \"%s\" at offset %d, %s", e.Token, e.Offset, e.Reason)
+}
 
 // Pruner should return true if the given Index entry sould be removed
 type Pruner func(*ReleaseIndexEntry) bool
 
+// pruneCounter tracks the number of versions and revisions of a
+// package seen so far
+type pruneCounter struct {
+	epoch       int
+	version     string
+	versionCnt  int
+	revision    string
+	revisionCnt int
+}
+
+// add counts the passed version, it returns true if the version is
+// the same as the previous one
+func (c *pruneCounter) add(v DebVersion) (same bool) {
+	switch {
+	case c.versionCnt == 0 || v.Version != c.version || v.Epoch != c.epoch:
+		c.versionCnt++
+		c.epoch = v.Epoch
+		c.version = v.Version
+		c.revision = v.Revision
+		c.revisionCnt = 1
+	case v.Revision != c.revision:
+		c.revisionCnt++
+		c.revision = v.Revision
+	default:
+		return true
+	}
+	return false
+}
+
+// exceeded returns true if the counter has gone beyond the
+// limits of the rule
+func (rule *PruneRule) exceeded(c *pruneCounter) bool {
+	if rule.limitVersions && int64(c.versionCnt) > rule.retainVersions+1 {
+		return true
+	}
+
+	if rule.limitRevisions && int64(c.revisionCnt) > rule.retainRevisions+1 {
+		return true
+	}
+
+	return false
+}
+
 // MakePruner creates a new pruner. The pruner is a function that takes
 // a repository item, and decides if it will be included or not (true
 // implies the item should be removed, false means it should be kept)
+//   Entries must be passed in index order. An entry is only removed if
+// every architecture it provides is beyond the limits of the rule
+// that applies to it.
 func (rules PruneRuleSet) MakePruner() Pruner {
+	now := time.Now()
 	currPkg := ""
-	currEpoch := 0
-	currVersion := ""
-	currVersionCnt := 0
-	currRevision := ""
-	currRevisionCnt := 0
-	var currRule *PruneRule
+	var counters map[string]*pruneCounter
 
 	return func(entry *ReleaseIndexEntry) (prune bool) {
 		item := entry.SourceItem
-		if item.Name != currPkg {
+		if item.Name != currPkg || counters == nil {
 			currPkg = item.Name
-			currEpoch = item.Version.Epoch
-			currVersion = item.Version.Version
-			currVersionCnt = 1
-			currRevision = item.Version.Revision
-			currRevisionCnt = 1
-
-			// Try and find a prune rule to use
-			for _, r := range rules {
-				if r.pkgPattern.MatchString(currPkg) {
-					currRule = r
-					break
-				} else {
-					currRule = nil
-				}
+			counters = make(map[string]*pruneCounter)
+		}
+
+		prune = !rules.pinned(item.Name, item.Version)
+		for key, rule := range rules.entryRules(entry) {
+			c, ok := counters[key]
+			if !ok {
+				c = &pruneCounter{}
+				counters[key] = c
 			}
 
-			return false
-		}
+			if c.add(item.Version) || rule == nil || !rule.exceeded(c) {
+				prune = false
+				continue
+			}
 
-		if item.Version.Version != currVersion || item.Version.Epoch != currEpoch {
-			currVersionCnt++
-			currVersion = item.Version.Version
-			currEpoch = item.Version.Epoch
-			currRevision = item.Version.Revision
-			currRevisionCnt = 1
-		} else {
-			if item.Version.Revision != currRevision {
-				currRevisionCnt++
-				currRevision = item.Version.Revision
-			} else {
-				// The two versions match, shouldn't prune this
-				return false
+			if rule.retainNewer != 0 &&
+				!entry.UploadDate.IsZero() &&
+				entry.UploadDate.After(now.Add(-1*rule.retainNewer)) {
+				prune = false
 			}
 		}
 
-		if currRule.limitVersions {
-			if int64(currVersionCnt) > currRule.retainVersions+1 {
-				return true
-			}
+		return prune
+	}
+}
+
+// pinned returns true if the given package version has been
+// pinned
+func (rules PruneRuleSet) pinned(name string, version DebVersion) bool {
+	for _, p := range rules.Pins {
+		if p.Name == name && DebVersionCompare(p.Version, version) == 0 {
+			return true
 		}
+	}
+	return false
+}
 
-		if currRule.limitRevisions {
-			if int64(currRevisionCnt) > currRule.retainRevisions+1 {
-				return true
-			}
+// findRule returns the first rule that matches the given package
+// and architecture
+func (rules PruneRuleSet) findRule(name, arch string) *PruneRule {
+	for _, r := range rules.Rules {
+		if r.arch != "" && r.arch != arch {
+			continue
+		}
+		if r.pkgPattern.MatchString(name) {
+			return r
+		}
+	}
+	return nil
+}
+
+// entryRules returns the rules that apply to an entry, keyed by the
+// architecture versions should be counted against. Rules that do not
+// count per architecture are keyed by the empty string
+func (rules PruneRuleSet) entryRules(entry *ReleaseIndexEntry) map[string]*PruneRule {
+	var archs []string
+	if len(entry.SourceItem.Files) > 0 {
+		archs = append(archs, "source")
+	}
+	for _, b := range entry.BinaryItems {
+		archs = append(archs, b.Architecture)
+	}
+	if len(archs) == 0 {
+		archs = append(archs, "source")
+	}
+
+	res := make(map[string]*PruneRule)
+	for _, arch := range archs {
+		rule := rules.findRule(entry.SourceItem.Name, arch)
+		key := ""
+		if rule != nil && (rule.perArch || rule.arch != "") {
+			key = arch
+		}
+		res[key] = rule
+	}
+
+	return res
+}
+
+// String formats the rule set in a form that can be parsed by
+// ParsePruneRules
+func (rules PruneRuleSet) String() string {
+	var strs []string
+	for _, p := range rules.Pins {
+		strs = append(strs, "pin="+p.Name+"_"+p.Version.String())
+	}
+	for _, r := range rules.Rules {
+		strs = append(strs, r.String())
+	}
+	return strings.Join(strs, ",")
+}
+
+// String formats the rule in a form that can be parsed by
+// ParsePruneRule
+func (rule *PruneRule) String() string {
+	limitStr := func(limit bool, retain int64) string {
+		if !limit {
+			return "*"
 		}
+		return strconv.FormatInt(retain, 10)
+	}
+
+	str := fmt.Sprintf("%s_%s-%s",
+		rule.pkgPattern.String(),
+		limitStr(rule.limitVersions, rule.retainVersions),
+		limitStr(rule.limitRevisions, rule.retainRevisions))
+
+	if rule.arch != "" {
+		str += " arch=" + rule.arch
+	}
+
+	if rule.perArch {
+		str += " per-arch"
+	}
 
-		return false
+	if rule.retainNewer != 0 {
+		day := 24 * time.Hour
+		if rule.retainNewer%day == 0 {
+			str += fmt.Sprintf(" newer=%dd", rule.retainNewer/day)
+		} else {
+			str += " newer=" + rule.retainNewer.String()
+		}
 	}
+
+	return str
 }
 
-// ParsePruneRules converts a string into a set of pruning rules
+// ParsePruneRules converts a string into a set of pruning rules. Rules
+// are comma seperated, and are either a rule of the form accepted by
+// ParsePruneRule, or a pin of the form pin=package_version, which will
+// exempt that version of the package from pruning
 func ParsePruneRules(rulesStr string) (PruneRuleSet, error) {
-	ruleStrings := strings.Split(rulesStr, ",")
-	var rules []*PruneRule
+	var rules PruneRuleSet
+	offset := 0
 
-	for _, ruleStr := range ruleStrings {
-		rule, err := ParsePruneRule(ruleStr)
-		if err != nil {
-			return rules, err
+	for _, ruleStr := range strings.Split(rulesStr, ",") {
+		trimmed := strings.TrimSpace(ruleStr)
+		start := offset + strings.Index(ruleStr, trimmed)
+
+		if strings.HasPrefix(trimmed, "pin=") {
+			pin, err := parsePrunePin(trimmed)
+			if err != nil {
+				err.Offset += start
+				return rules, err
+			}
+			rules.Pins = append(rules.Pins, pin)
+		} else {
+			rule, err := ParsePruneRule(ruleStr)
+			if err != nil {
+				if perr, ok := err.(*PruneRuleError); ok {
+					perr.Offset += offset
+				}
+				return rules, err
+			}
+			rules.Rules = append(rules.Rules, rule)
 		}
-		rules = append(rules, rule)
+
+		offset += len(ruleStr) + 1
 	}
 
 	return rules, nil
 }
 
+func parsePrunePin(pinStr string) (PrunePin, *PruneRuleError) {
+	var pin PrunePin
+
+	parts := strings.SplitN(strings.TrimPrefix(pinStr, "pin="), "_", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" || strings.ContainsAny(parts[1], " \t") {
+		return pin, &PruneRuleError{pinStr, 0, "expected pin=package_version"}
+	}
+
+	version, err := ParseDebVersion(parts[1])
+	if err != nil {
+		return pin, &PruneRuleError{pinStr, 0, err.Error()}
+	}
+
+	pin.Name = parts[0]
+	pin.Version = version
+	return pin, nil
+}
+
 var ruleRegex = regexp.MustCompile(`^(.*)_(\d+|\*)-(\d+|\*)$`)
+var ruleTokenRegex = regexp.MustCompile(`\S+`)
 
 // ParsePruneRule converts a string describing a single pruning rule
-// into an internal description used for pruning
+// into an internal description used for pruning. A rule takes the form
+// pattern_V-R, optionally followed by space seperated options:
+//   arch=A    only apply the rule to architecture A, versions of A are counted separately
+//   per-arch  count versions of each architecture separately
+//   newer=D   always keep items uploaded within duration D (e.g. 30d)
 func ParsePruneRule(ruleStr string) (*PruneRule, error) {
 	var rule PruneRule
 	var err error
 
-	matches := ruleRegex.FindStringSubmatch(ruleStr)
+	tokens := ruleTokenRegex.FindAllStringIndex(ruleStr, -1)
+	if len(tokens) == 0 {
+		return &rule, &PruneRuleError{ruleStr, 0, "empty rule"}
+	}
+
+	head := ruleStr[tokens[0][0]:tokens[0][1]]
+	matches := ruleRegex.FindStringSubmatch(head)
 
 	if len(matches) != 4 {
-		return &rule, errors.New("invalid prune rule \"" + ruleStr + "\"")
+		return &rule, &PruneRuleError{head, tokens[0][0], "expected pattern_versions-revisions"}
 	}
 
 	rule.pkgPattern, err = regexp.Compile(matches[1])
 	if err != nil {
-		return nil, err
+		return nil, &PruneRuleError{head, tokens[0][0], err.Error()}
 	}
 
 	if matches[2] == "*" {
 		rule.limitVersions = false
 	} else {
 		rule.limitVersions = true
-		rule.retainVersions, _ = strconv.ParseInt(matches[2], 10, 16)
+		rule.retainVersions, err = strconv.ParseInt(matches[2], 10, 16)
+		if err != nil {
+			return nil, &PruneRuleError{head, tokens[0][0], "version limit out of range"}
+		}
 	}
 
 	if matches[3] == "*" {
 		rule.limitRevisions = false
 	} else {
 		rule.limitRevisions = true
-		rule.retainRevisions, _ = strconv.ParseInt(matches[3], 10, 16)
+		rule.retainRevisions, err = strconv.ParseInt(matches[3], 10, 16)
+		if err != nil {
+			return nil, &PruneRuleError{head, tokens[0][0], "revision limit out of range"}
+		}
+	}
+
+	for _, loc := range tokens[1:] {
+		tok := ruleStr[loc[0]:loc[1]]
+		switch {
+		case tok == "per-arch":
+			rule.perArch = true
+		case strings.HasPrefix(tok, "arch="):
+			rule.arch = strings.TrimPrefix(tok, "arch=")
+			if rule.arch == "" {
+				return nil, &PruneRuleError{tok, loc[0], "missing architecture"}
+			}
+		case strings.HasPrefix(tok, "newer="):
+			rule.retainNewer, err = ParseAge(strings.TrimPrefix(tok, "newer="))
+			if err != nil {
+				return nil, &PruneRuleError{tok, loc[0], err.Error()}
+			}
+			if rule.retainNewer <= 0 {
+				return nil, &PruneRuleError{tok, loc[0], "age must be positive"}
+			}
+		default:
+			return nil, &PruneRuleError{tok, loc[0], "unknown option"}
+		}
 	}
+
 	return &rule, nil
 }
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+)
+
+func httpPruneHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	switch r.Method {
+	case "POST":
+		return handleWithWriteLock(doHTTPPruneHandler, ctx, w, r)
+	default:
+		return sendResponse(w, http.StatusMethodNotAllowed, nil)
+	}
+}
+
+// Apply the current prune rules of a distribution to its whole index
+func doHTTPPruneHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	if !AuthorisedAdmin(ctx, w, r) {
+		return sendResponse(w, http.StatusUnauthorized, nil)
+	}
+
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	_, err := state.Archive.GetDist(name)
+	switch {
+	case err == nil:
+	case os.IsNotExist(err):
+		return sendResponse(w, http.StatusNotFound, nil)
+	default:
+		return &appError{Error: fmt.Errorf("failed to retrieve store reference, %v", err)}
+	}
+
+	res, err := updateWithGenHooks(name, func() error {
+		return state.Archive.PruneDist(name)
+	})
+	if err != nil {
+		return &appError{Error: fmt.Errorf("failed to prune distribution, %v", err)}
+	}
+
+	return sendOKResponse(w, res)
+}
+
+func httpPrunePreviewHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	switch r.Method {
+	case "POST":
+		return handleWithReadLock(doHTTPPrunePreviewHandler, ctx, w, r)
+	default:
+		return sendResponse(w, http.StatusMethodNotAllowed, nil)
+	}
+}
+
+// Report which index entries and releases would be removed by the
+// prune and trim configuration of a distribution. The current settings
+// are used, unless changes to them are given in the body of the request.
+func doHTTPPrunePreviewHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	rel, err := state.Archive.GetDist(name)
+	switch {
+	case err == nil:
+	case os.IsNotExist(err):
+		return sendResponse(w, http.StatusNotFound, nil)
+	default:
+		return &appError{Error: fmt.Errorf("failed to retrieve store reference, %v", err)}
+	}
+
+	var d policyUpdate
+	err = json.NewDecoder(r.Body).Decode(&d)
+	if err != nil && err != io.EOF {
+		return sendResponse(w, http.StatusBadRequest, nil)
+	}
+
+	cfg := *rel.Config()
+	if _, err = d.apply(&cfg); err != nil {
+		return sendResponse(w, http.StatusBadRequest, err.Error())
+	}
+
+	preview, err := PreviewPolicy(state.Archive, rel, &cfg)
+	if err != nil {
+		return &appError{Error: err}
+	}
+
+	return sendOKResponse(w, preview)
+}
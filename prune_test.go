@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"reflect"
 	"testing"
+	"time"
 )
 
 var testRepoPruneInput = []*ReleaseIndexEntry{
@@ -250,3 +251,143 @@ func TestPruneRules(t *testing.T) {
 		}
 	}
 }
+
+func testPruneArchEntry(name, version string, age time.Duration, archs ...string) *ReleaseIndexEntry {
+	entry := &ReleaseIndexEntry{
+		SourceItem: ReleaseIndexEntryItem{Name: name, Version: MustParseDebVersion(version)},
+	}
+	if age != 0 {
+		entry.UploadDate = time.Now().Add(-1 * age)
+	}
+	for _, a := range archs {
+		entry.BinaryItems = append(entry.BinaryItems, ReleaseIndexEntryItem{Name: name, Architecture: a})
+	}
+	return entry
+}
+
+var testRepoPruneArchInput = []*ReleaseIndexEntry{
+	testPruneArchEntry("pkgg", "3-1", time.Hour, "amd64"),
+	testPruneArchEntry("pkgg", "2-1", 10*24*time.Hour, "amd64", "i386"),
+	testPruneArchEntry("pkgg", "1-1", 100*24*time.Hour, "amd64", "i386"),
+
+	testPruneArchEntry("pkgh", "2-1", 0, "amd64"),
+	testPruneArchEntry("pkgh", "1-1", 0, "amd64"),
+}
+
+var testRepoPruneArch = []struct {
+	rules  string
+	output []*ReleaseIndexEntry
+}{
+	{".*_0-0", []*ReleaseIndexEntry{
+		testRepoPruneArchInput[0],
+		testRepoPruneArchInput[3],
+	}},
+	{".*_0-0 per-arch", []*ReleaseIndexEntry{
+		testRepoPruneArchInput[0],
+		testRepoPruneArchInput[1],
+		testRepoPruneArchInput[3],
+	}},
+	{"pkgg_0-0 arch=i386,.*_0-0", []*ReleaseIndexEntry{
+		testRepoPruneArchInput[0],
+		testRepoPruneArchInput[1],
+		testRepoPruneArchInput[3],
+	}},
+	{".*_0-0 newer=30d", []*ReleaseIndexEntry{
+		testRepoPruneArchInput[0],
+		testRepoPruneArchInput[1],
+		testRepoPruneArchInput[3],
+	}},
+	{"pin=pkgh_1-1,.*_0-0", []*ReleaseIndexEntry{
+		testRepoPruneArchInput[0],
+		testRepoPruneArchInput[3],
+		testRepoPruneArchInput[4],
+	}},
+}
+
+func TestPruneRulesArch(t *testing.T) {
+	for i, tt := range testRepoPruneArch {
+		r, err := ParsePruneRules(tt.rules)
+		if err != nil {
+			t.Errorf("TestPruneRulesArch[%d]: ParsePruneRules failed: %s", i, err.Error())
+			continue
+		}
+		p := r.MakePruner()
+		var res []*ReleaseIndexEntry
+		for _, j := range testRepoPruneArchInput {
+			if !p(j) {
+				res = append(res, j)
+			}
+		}
+		if !reflect.DeepEqual(res, tt.output) {
+			t.Errorf("TestPruneRulesArch[%d]: %v, failed:\nExpected:\n%v\nGot:\n%v\n",
+				i+1,
+				tt.rules,
+				formatTestItemList(tt.output),
+				formatTestItemList(res))
+		}
+	}
+}
+
+var testPruneRulesString = []struct {
+	rules  string
+	output string
+}{
+	{".*_*-*", ".*_*-*"},
+	{"pkgf_2-0,.*_0-0", "pkgf_2-0,.*_0-0"},
+	{"pin=pkgh_1:1.0-1,.*_0-0 arch=i386 per-arch newer=30d", "pin=pkgh_1:1.0-1,.*_0-0 arch=i386 per-arch newer=30d"},
+	{".*_1-* newer=36h", ".*_1-* newer=36h0m0s"},
+	{" .*_0-0  newer=720h , pin=pkgh_2-1", "pin=pkgh_2-1,.*_0-0 newer=30d"},
+}
+
+func TestPruneRulesString(t *testing.T) {
+	for i, tt := range testPruneRulesString {
+		r, err := ParsePruneRules(tt.rules)
+		if err != nil {
+			t.Errorf("TestPruneRulesString[%d]: ParsePruneRules failed: %s", i, err.Error())
+			continue
+		}
+		if r.String() != tt.output {
+			t.Errorf("TestPruneRulesString[%d]: expected %v, got %v", i, tt.output, r.String())
+			continue
+		}
+		r2, err := ParsePruneRules(r.String())
+		if err != nil {
+			t.Errorf("TestPruneRulesString[%d]: reparse failed: %s", i, err.Error())
+			continue
+		}
+		if r2.String() != r.String() {
+			t.Errorf("TestPruneRulesString[%d]: did not round trip, %v != %v", i, r2.String(), r.String())
+		}
+	}
+}
+
+var testPruneRulesInvalid = []struct {
+	rules  string
+	token  string
+	offset int
+}{
+	{"", "", 0},
+	{".*_0-0 newer=9x", "newer=9x", 7},
+	{".*_0-0 newer=-1h", "newer=-1h", 7},
+	{".*_0-0,pin=foo", "pin=foo", 7},
+	{".*_0-0, bogus", "bogus", 8},
+	{"pkg_0-0 sideways", "sideways", 8},
+	{"pkg_0-0 arch=", "arch=", 8},
+	{"pkg_0-0,(_1-1", "(_1-1", 8},
+	{"pkg_99999-0", "pkg_99999-0", 0},
+}
+
+func TestPruneRulesInvalid(t *testing.T) {
+	for i, tt := range testPruneRulesInvalid {
+		_, err := ParsePruneRules(tt.rules)
+		perr, ok := err.(*PruneRuleError)
+		if !ok {
+			t.Errorf("TestPruneRulesInvalid[%d]: %v, expected PruneRuleError, got %v", i, tt.rules, err)
+			continue
+		}
+		if perr.Token != tt.token || perr.Offset != tt.offset {
+			t.Errorf("TestPruneRulesInvalid[%d]: %v, expected token %q at %d, got %q at %d",
+				i, tt.rules, tt.token, tt.offset, perr.Token, perr.Offset)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Releases are published by building the dists directory for a
+// distribution into a new generation directory, then switching a symlink
+// in the dists directory over to the new generation. Clients never see a
+// partially built distribution, and the previous generation is kept for a
+// grace period so that in progress downloads can complete.
+//
+//   dists/<codename> -> ../.generations/<codename>/<gen>/dists/<codename>
+//
+// The pool is shared by all generations. Files are added to the pool
+// before the generation that uses them is published, and removed when the
+// last generation that used them is removed.
+
+// DefaultPublishGrace is how long a replaced generation of a
+// distribution is kept by default
+const DefaultPublishGrace = 10 * time.Minute
+
+// generationsDir returns the directory holding the published
+// generations of a distribution
+func generationsDir(base, codename string) string {
+	return base + "/.generations/" + codename
+}
+
+// newGeneration returns the path for a new generation of a
+// distribution. Generations are named so that they sort by the time
+// they were created
+func newGeneration(base, codename string, now time.Time) string {
+	return fmt.Sprintf("%s/%020d", generationsDir(base, codename), now.UnixNano())
+}
+
+// publishGeneration switches the published dists directory of a
+// distribution to the given generation
+func publishGeneration(base, codename, gen string) error {
+	target := "../.generations/" + codename + "/" + filepath.Base(gen) + "/dists/" + codename
+	return swapSymlink(target, base+"/dists/"+codename)
+}
+
+// currentGeneration returns the path of the currently published
+// generation of a distribution, or an empty string if there is none
+func currentGeneration(base, codename string) string {
+	target, err := os.Readlink(base + "/dists/" + codename)
+	if err != nil {
+		return ""
+	}
+
+	gen := filepath.Base(filepath.Dir(filepath.Dir(target)))
+	return generationsDir(base, codename) + "/" + gen
+}
+
+// generationRelease returns the id of the release published in
+// a generation
+func generationRelease(gen string) string {
+	id, err := ioutil.ReadFile(gen + "/release")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(id))
+}
+
+// swapSymlink atomically replaces link with a symlink to target
+func swapSymlink(target, link string) error {
+	err := os.MkdirAll(filepath.Dir(link), 0777)
+	if err != nil {
+		return err
+	}
+
+	tmp := link + ".new"
+	os.Remove(tmp)
+	err = os.Symlink(target, tmp)
+	if err != nil {
+		return err
+	}
+
+	// Directories published before generations were used have to be
+	// removed before they can be replaced
+	if info, err := os.Lstat(link); err == nil && info.Mode()&os.ModeSymlink == 0 {
+		log.Printf("Replacing unversioned directory %v", link)
+		os.RemoveAll(link)
+	}
+
+	err = os.Rename(tmp, link)
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// removeOldGenerations removes the generations of a distribution that
+// were replaced by a later generation more than grace ago. The current
+// generation, and any being built, are always kept. Pool files retired
+// by a removed generation are removed too, unless they are in poolFiles
+func removeOldGenerations(base, codename, current string, grace time.Duration, now time.Time, poolFiles map[string]StoreID) {
+	genBase := generationsDir(base, codename)
+	entries, err := ioutil.ReadDir(genBase)
+	if err != nil {
+		log.Printf("Could not list generations of %v, %v", codename, err)
+		return
+	}
+
+	var gens []string
+	for _, e := range entries {
+		gens = append(gens, e.Name())
+	}
+	sort.Strings(gens)
+
+	current = filepath.Base(current)
+	for i, gen := range gens {
+		if gen >= current {
+			break
+		}
+
+		// A generation was replaced when the following one was created
+		replaced, err := strconv.ParseInt(gens[i+1], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		if now.Sub(time.Unix(0, replaced)) < grace {
+			continue
+		}
+
+		log.Printf("Removing old generation %v of %v", gen, codename)
+		removeRetiredPoolFiles(base, genBase+"/"+gen, poolFiles)
+		err = os.RemoveAll(genBase + "/" + gen)
+		if err != nil {
+			log.Printf("Removing old generation failed, %v", err)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestReifyGenerations(t *testing.T) {
+	a, clean, err := makeTestArchive(t)
+	if err != nil {
+		return
+	}
+	defer clean()
+
+	rootid, err := a.GetReleaseRoot(Release{CodeName: "test", Suite: "test", Version: "0"})
+	if err != nil {
+		t.Fatalf("creating release root failed, %v", err)
+	}
+	emptyidx, _ := a.EmptyReleaseIndex()
+	relid, err := NewRelease(a, rootid, emptyidx, []ReleaseLogAction{})
+	if err != nil {
+		t.Fatalf("creating release failed, %v", err)
+	}
+
+	base := a.PublicDir()
+	a.publishGrace = time.Hour
+
+	for i := 0; i < 2; i++ {
+		err = a.ReifyRelease(relid)
+		if err != nil {
+			t.Fatalf("reifying release failed, %v", err)
+		}
+	}
+
+	info, err := os.Lstat(base + "/dists/test")
+	if err != nil {
+		t.Fatalf("published directory missing, %v", err)
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("dists/test should be a symlink")
+	}
+
+	if info, err := os.Lstat(base + "/pool/test"); err != nil || !info.IsDir() {
+		t.Errorf("pool/test should be a directory, %v", err)
+	}
+
+	if _, err := os.Stat(base + "/dists/test/Release"); err != nil {
+		t.Errorf("Release file not published, %v", err)
+	}
+
+	gens, _ := ioutil.ReadDir(generationsDir(base, "test"))
+	if len(gens) != 2 {
+		t.Errorf("previous generation should be kept during the grace period, got %v generations", len(gens))
+	}
+
+	a.publishGrace = 0
+	err = a.ReifyRelease(relid)
+	if err != nil {
+		t.Fatalf("reifying release failed, %v", err)
+	}
+
+	gens, _ = ioutil.ReadDir(generationsDir(base, "test"))
+	if len(gens) != 1 {
+		t.Errorf("replaced generations should be removed after the grace period, got %v generations", len(gens))
+	}
+
+	dest, _ := os.Readlink(base + "/dists/test")
+	if len(gens) == 0 || dest != "../.generations/test/"+gens[0].Name()+"/dists/test" {
+		t.Errorf("dists symlink points to the wrong generation, %v", dest)
+	}
+}
+
+func TestSwapSymlinkReplacesDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("creating temp dir failed, %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(dir+"/dists/test/main", 0777)
+	os.MkdirAll(dir+"/gen/test", 0777)
+
+	err = swapSymlink("../gen/test", dir+"/dists/test")
+	if err != nil {
+		t.Fatalf("swapping symlink failed, %v", err)
+	}
+
+	dest, err := os.Readlink(dir + "/dists/test")
+	if err != nil || dest != "../gen/test" {
+		t.Errorf("expected symlink to ../gen/test, got %v, %v", dest, err)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// releaseNeedsRefresh returns true if the release files of a release
+// have less than half of their validity period remaining
+func releaseNeedsRefresh(rel *Release, now time.Time) bool {
+	validFor := rel.Config().ValidDuration()
+	if validFor == 0 {
+		return false
+	}
+
+	return now.After(rel.ValidUntil().Add(-1 * validFor / 2))
+}
+
+// refreshReleases periodically re-signs the release files of any
+// distributions that will soon expire
+func refreshReleases(interval time.Duration) {
+	for range time.Tick(interval) {
+		refreshExpiringDists(time.Now())
+	}
+}
+
+// refreshExpiringDists refreshes the release files of all distributions
+// that need it
+func refreshExpiringDists(now time.Time) {
+	state.Lock.WriteLock()
+	defer state.Lock.WriteUnLock()
+
+	for name := range state.Archive.Dists() {
+		rel, err := state.Archive.GetDist(name)
+		if err != nil {
+			log.Printf("Could not check release expiry for %v, %v", name, err)
+			continue
+		}
+
+		if !releaseNeedsRefresh(rel, now) {
+			continue
+		}
+
+		log.Printf("Refreshing release files for %v, valid until %v", name, rel.ValidUntil())
+		_, err = updateWithGenHooks(name, func() error {
+			return state.Archive.RefreshDist(name)
+		})
+		if err != nil {
+			log.Printf("Refreshing release files for %v failed, %v", name, err)
+		}
+	}
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"crypto/sha1"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"io/ioutil"
 	"log"
@@ -21,16 +23,30 @@ import (
 // Architecture collates all the information for an architecture
 // within a component of a release.
 type Architecture struct {
-	Name       string
-	PackagesGz StoreID
+	Name               string
+	PackagesGz         StoreID
+	PackagesCompressed []CompressedIndex
+	ContentsGz         StoreID
+	ReleaseFile        StoreID     // The Release file of the binary-<arch> directory
+	TranslationsPart   StoreID     // The translations of the packages in this architecture
+	IndexFiles         []IndexFile // The index files, as listed in the Release file
+	Fingerprint        string      // Identifies the items the indexes were built from
 }
 
 // Component collates all the information for a component
 // within a release
 type Component struct {
-	Name          string
-	Architectures []Architecture
-	SourcesGz     StoreID
+	Name               string
+	Architectures      []Architecture
+	SourcesGz          StoreID
+	SourcesCompressed  []CompressedIndex
+	SourcesRelease     StoreID // The Release file of the source directory
+	SourcesIndexFiles  []IndexFile
+	SourcesFingerprint string
+
+	TranslationsGz         StoreID
+	TranslationsCompressed []CompressedIndex
+	TranslationsIndexFiles []IndexFile
 }
 
 // Release collects all the information for a release
@@ -65,6 +81,12 @@ type ReleaseLogActionType int
 //	ActionPRUNE       - An item was pruned by the pruning rules
 //	ActionSKIPPRESENT - An item was skipped, as it alerady existed
 //	ActionSKIPPRUNE   - An item was was skipped, dur to purge rules
+//	ActionTRIM        - The release history was trimmed
+//	ActionCONFIGCHANGE - The release configuration was changed
+//	ActionROLLBACK    - The release was rolled back to an earlier release
+//	ActionFORK        - The release was forked from another distribution
+//	ActionREFRESH     - The release files were regenerated before they expired
+//	ActionSNAPSHOT    - The release files were regenerated, without an expiry, for a snapshot
 const (
 	ActionUNKNOWN      ReleaseLogActionType = 1 << iota
 	ActionADD          ReleaseLogActionType = 2
@@ -74,6 +96,10 @@ const (
 	ActionSKIPPRUNE    ReleaseLogActionType = 6
 	ActionTRIM         ReleaseLogActionType = 7
 	ActionCONFIGCHANGE ReleaseLogActionType = 8
+	ActionROLLBACK     ReleaseLogActionType = 9
+	ActionFORK         ReleaseLogActionType = 10
+	ActionREFRESH      ReleaseLogActionType = 11
+	ActionSNAPSHOT     ReleaseLogActionType = 12
 )
 
 // ReleaseLogAction desribes an action taken during a merge or update
@@ -82,46 +108,300 @@ type ReleaseLogAction struct {
 	Description string
 }
 
+// archTempData tracks the index files of an architecture while they are
+// being generated
 type archTempData struct {
-	packagesFileWriter   *WriteHasher
-	packagesGzFile       *WriteHasher
-	packagesGzStore      StoreWriteCloser
-	packagesGzFileWriter io.WriteCloser
-	packagesWriter       io.Writer
-	packagesSize         int64
-	packagesMD5          string
-	packagesSHA1         string
-	packagesSHA256       string
-	packagesGzSize       int64
-	packagesGzMD5        string
-	packagesGzSHA1       string
-	packagesGzSHA256     string
-	PackagesGzID         StoreID
+	packagesFile       *WriteHasher
+	packagesGz         *compressedTempData
+	packagesCompressed []*compressedTempData
+	packagesWriter     io.Writer
+	contents           contentsTempData
+	translations       *translationTempData
+	translationsStore  StoreWriteCloser
 }
 
+// compTempData tracks the index files of a component while they are
+// being generated. Only the indexes that have changed since the parent
+// release are regenerated, archs holds the architectures being regenerated,
+// and sourcesWriter is nil if the Sources index is unchanged
 type compTempData struct {
-	archs               map[string]*archTempData
-	sourcesFileWriter   *WriteHasher
-	sourcesGzFile       *WriteHasher
-	sourcesGzStore      StoreWriteCloser
-	sourcesGzFileWriter io.WriteCloser
-	sourcesWriter       io.Writer
-	sourcesSize         int64
-	sourcesMD5          string
-	sourcesSHA1         string
-	sourcesSHA256       string
-	sourcesGzSize       int64
-	sourcesGzMD5        string
-	sourcesGzSHA1       string
-	sourcesGzSHA256     string
-	SourcesGzID         StoreID
+	archs              map[string]*archTempData
+	archFingerprints   map[string]string
+	sourcesFingerprint string
+	sourcesFile        *WriteHasher
+	sourcesGz          *compressedTempData
+	sourcesCompressed  []*compressedTempData
+	sourcesWriter      io.Writer
+	prev               *Component
 }
 
 type relTempData map[string]*compTempData
 
+// indexFingerprints accumulates hashes of the index items that make up
+// each architecture, and the sources, of each component
+type indexFingerprints map[string]hash.Hash
+
+func (f indexFingerprints) add(comp, arch string, item ReleaseIndexEntryItem) {
+	key := comp + "/" + arch
+	h, ok := f[key]
+	if !ok {
+		h = sha1.New()
+		f[key] = h
+	}
+
+	fmt.Fprintf(h, "%s %s %s %s", item.Name, item.Version.String(), item.ControlID, item.ContentsID)
+	for _, file := range item.Files {
+		fmt.Fprintf(h, " %s", file.Name)
+	}
+	fmt.Fprintln(h)
+}
+
+// sum combines the hashes of the given architectures of a component into
+// a fingerprint of an index. base should identify anything else that
+// appears in the index, such as the codename used in the pool paths
+func (f indexFingerprints) sum(base, comp string, archs ...string) string {
+	h := sha1.New()
+	fmt.Fprintln(h, base)
+	for _, arch := range archs {
+		if ah, ok := f[comp+"/"+arch]; ok {
+			h.Write(ah.Sum(nil))
+		}
+		fmt.Fprintln(h)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// contentsTempData maps files to the packages that install them
+type contentsTempData map[string][]string
+
+func (c contentsTempData) add(files []string, location string) {
+	for _, f := range files {
+		c[f] = append(c[f], location)
+	}
+}
+
+// writeContents stores a gzip compressed Contents index
+func (r *Release) writeContents(contents contentsTempData) (*compressedTempData, error) {
+	c, err := r.newGzTempData()
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make(sort.StringSlice, 0, len(contents))
+	for p := range contents {
+		paths = append(paths, p)
+	}
+	paths.Sort()
+
+	for _, p := range paths {
+		fmt.Fprintf(c.writer, "%s %s\n", p, strings.Join(contents[p], ","))
+	}
+
+	return c, c.finish()
+}
+
+// compressedTempData tracks an additional compressed copy of an index
+// file while it is being generated
+type compressedTempData struct {
+	compression string
+	file        *WriteHasher
+	store       StoreWriteCloser
+	writer      io.WriteCloser
+	size        int64
+	md5         string
+	sha1        string
+	sha256      string
+	sha512      string
+	id          StoreID
+}
+
+// newGzTempData starts a gzip compressed index file
+func (r *Release) newGzTempData() (*compressedTempData, error) {
+	store, err := r.store.Store()
+	if err != nil {
+		return nil, err
+	}
+
+	file := MakeWriteHasher(store)
+	return &compressedTempData{
+		compression: "gz",
+		file:        file,
+		store:       store,
+		writer:      gzip.NewWriter(file),
+	}, nil
+}
+
+// newTranslationTempData starts the Translation-en index of a component
+func (r *Release) newTranslationTempData() (*translationTempData, error) {
+	gz, err := r.newGzTempData()
+	if err != nil {
+		return nil, err
+	}
+
+	compressed, extraWriters := r.newCompressedTempData()
+	return &translationTempData{
+		gz:         gz,
+		compressed: compressed,
+		writer:     io.MultiWriter(append([]io.Writer{gz.writer}, extraWriters...)...),
+		seen:       make(map[string]bool),
+	}, nil
+}
+
+// newCompressedTempData starts the additional compressed copies of an
+// index file, the returned writers should be added to the index writer
+func (r *Release) newCompressedTempData() ([]*compressedTempData, []io.Writer) {
+	var files []*compressedTempData
+	var writers []io.Writer
+
+	for _, compression := range r.Config().IndexCompressions {
+		store, err := r.store.Store()
+		if err != nil {
+			log.Printf("failed to create %v index, %v", compression, err)
+			continue
+		}
+
+		file := MakeWriteHasher(store)
+		writer, err := NewCompressWriter(compression, file)
+		if err != nil {
+			log.Printf("failed to create %v index, %v", compression, err)
+			store.Close()
+			continue
+		}
+
+		files = append(files, &compressedTempData{
+			compression: compression,
+			file:        file,
+			store:       store,
+			writer:      writer,
+		})
+		writers = append(writers, writer)
+	}
+
+	return files, writers
+}
+
+// finish completes the compressed file and collects its details
+func (c *compressedTempData) finish() error {
+	err := c.writer.Close()
+	c.store.Close()
+	if err != nil {
+		return err
+	}
+
+	c.size = c.file.Count()
+	c.md5 = hex.EncodeToString(c.file.MD5Sum())
+	c.sha1 = hex.EncodeToString(c.file.SHA1Sum())
+	c.sha256 = hex.EncodeToString(c.file.SHA256Sum())
+	c.sha512 = hex.EncodeToString(c.file.SHA512Sum())
+	c.id, err = c.store.Identity()
+	return err
+}
+
+// finishCompressedTempData completes a set of compressed files, any that
+// fail are logged and dropped
+func finishCompressedTempData(files []*compressedTempData) []*compressedTempData {
+	var done []*compressedTempData
+	for _, c := range files {
+		err := c.finish()
+		if err != nil {
+			log.Printf("failed to create %v index, %v", c.compression, err)
+			continue
+		}
+		done = append(done, c)
+	}
+	return done
+}
+
+// compressedIndexes lists the store ids of a set of compressed files
+func compressedIndexes(files []*compressedTempData) []CompressedIndex {
+	res := []CompressedIndex{}
+	for _, c := range files {
+		res = append(res, CompressedIndex{Compression: c.compression, ID: c.id})
+	}
+	return res
+}
+
+// indexFile describes the compressed file as an index file at path, with
+// the compression extension added
+func (c *compressedTempData) indexFile(path string) IndexFile {
+	return IndexFile{
+		Path:   path + "." + c.compression,
+		Size:   c.size,
+		MD5:    c.md5,
+		SHA1:   c.sha1,
+		SHA256: c.sha256,
+		SHA512: c.sha512,
+	}
+}
+
+// compressedIndexFiles lists a set of compressed copies of the index at path
+// for inclusion in the Release file
+func compressedIndexFiles(path string, files []*compressedTempData) []IndexFile {
+	var res []IndexFile
+	for _, c := range files {
+		res = append(res, c.indexFile(path))
+	}
+	return res
+}
+
+// hashedIndexFile describes an uncompressed index file at path, from the
+// hasher it was written through
+func hashedIndexFile(path string, h *WriteHasher) IndexFile {
+	return IndexFile{
+		Path:   path,
+		Size:   h.Count(),
+		MD5:    hex.EncodeToString(h.MD5Sum()),
+		SHA1:   hex.EncodeToString(h.SHA1Sum()),
+		SHA256: hex.EncodeToString(h.SHA256Sum()),
+		SHA512: hex.EncodeToString(h.SHA512Sum()),
+	}
+}
+
+// IndexFile describes an index file listed in the Release file
+type IndexFile struct {
+	Path   string // The path of the file, relative to the component, or dist, directory
+	Size   int64
+	MD5    string
+	SHA1   string
+	SHA256 string
+	SHA512 string
+}
+
+// prefixIndexFiles returns copies of the index files with the prefix
+// added to their paths
+func prefixIndexFiles(prefix string, files []IndexFile) []IndexFile {
+	var res []IndexFile
+	for _, f := range files {
+		f.Path = prefix + f.Path
+		res = append(res, f)
+	}
+	return res
+}
+
+// addReleaseIndexHashes adds a section for each of the supported hash
+// types to the release file paragraph
+func addReleaseIndexHashes(para ControlParagraph, files []IndexFile) {
+	hashes := []struct {
+		field string
+		sum   func(f IndexFile) string
+	}{
+		{"MD5Sum", func(f IndexFile) string { return f.MD5 }},
+		{"SHA1", func(f IndexFile) string { return f.SHA1 }},
+		{"SHA256", func(f IndexFile) string { return f.SHA256 }},
+		{"SHA512", func(f IndexFile) string { return f.SHA512 }},
+	}
+
+	for _, h := range hashes {
+		para.AddValue(h.field, "")
+		for _, f := range files {
+			para.AddValue(h.field, fmt.Sprintf("%v %d %v", h.sum(f), f.Size, f.Path))
+		}
+	}
+}
+
 // Parent returns the Release this Reelase was built from
 func (r *Release) Parent() (*Release, error) {
-	return r.store.GetRelease(r.id)
+	return r.store.GetRelease(r.ParentID)
 }
 
 // NewChild return a new Release
@@ -210,15 +490,235 @@ func (r *Release) updateReleaseSigFiles() bool {
 	return false
 }
 
+// newArch sets up the temporary data for an arch within a component, if
+// it has not already been set up
+func (r *Release) newArch(comp *compTempData, archName string) error {
+	if _, ok := comp.archs[archName]; ok {
+		return nil
+	}
+
+	var err error
+	arch := new(archTempData)
+
+	arch.packagesFile = MakeWriteHasher(ioutil.Discard)
+	arch.packagesGz, err = r.newGzTempData()
+	if err != nil {
+		return err
+	}
+	var extraWriters []io.Writer
+	arch.packagesCompressed, extraWriters = r.newCompressedTempData()
+	arch.packagesWriter = io.MultiWriter(append([]io.Writer{arch.packagesFile, arch.packagesGz.writer}, extraWriters...)...)
+	arch.contents = make(contentsTempData)
+
+	// The translations of each arch are kept so that the Translation-en
+	// index can be rebuilt without regenerating unchanged arches
+	arch.translationsStore, err = r.store.Store()
+	if err != nil {
+		return err
+	}
+	arch.translations = &translationTempData{
+		writer: arch.translationsStore,
+		seen:   make(map[string]bool),
+	}
+	comp.archs[archName] = arch
+
+	return nil
+}
+
+// newSources sets up the temporary data for the Sources index of a
+// component
+func (r *Release) newSources(comp *compTempData) error {
+	var err error
+	comp.sourcesFile = MakeWriteHasher(ioutil.Discard)
+	comp.sourcesGz, err = r.newGzTempData()
+	if err != nil {
+		return err
+	}
+	var extraWriters []io.Writer
+	comp.sourcesCompressed, extraWriters = r.newCompressedTempData()
+	comp.sourcesWriter = io.MultiWriter(append([]io.Writer{comp.sourcesFile, comp.sourcesGz.writer}, extraWriters...)...)
+
+	return nil
+}
+
+// finishSources completes the Sources index, and the Release file of the
+// source directory, of a component
+func (r *Release) finishSources(c *compTempData, comp *Component) {
+	c.sourcesCompressed = finishCompressedTempData(c.sourcesCompressed)
+	err := c.sourcesGz.finish()
+	if err != nil {
+		log.Printf("failed to create sources index, %v", err)
+		return
+	}
+
+	comp.SourcesGz = c.sourcesGz.id
+	comp.SourcesCompressed = compressedIndexes(c.sourcesCompressed)
+	comp.SourcesIndexFiles = append([]IndexFile{
+		hashedIndexFile("source/Sources", c.sourcesFile),
+		c.sourcesGz.indexFile("source/Sources"),
+	}, compressedIndexFiles("source/Sources", c.sourcesCompressed)...)
+
+	var releaseFile *WriteHasher
+	comp.SourcesRelease, releaseFile, err = r.writeIndexRelease(comp.Name, "source")
+	if err != nil {
+		log.Printf("failed to create sources release file, %v", err)
+		return
+	}
+	comp.SourcesIndexFiles = append(comp.SourcesIndexFiles, hashedIndexFile("source/Release", releaseFile))
+
+	comp.SourcesFingerprint = c.sourcesFingerprint
+}
+
+// finishArch completes the Packages and Contents indexes, the Release file
+// and the translations, of an architecture
+func (r *Release) finishArch(compName, archName string, a *archTempData, fingerprint string) Architecture {
+	arch := Architecture{Name: archName}
+	path := "binary-" + archName + "/Packages"
+
+	a.packagesCompressed = finishCompressedTempData(a.packagesCompressed)
+	err := a.packagesGz.finish()
+	if err != nil {
+		log.Printf("failed to create packages index, %v", err)
+	} else {
+		arch.PackagesGz = a.packagesGz.id
+		arch.PackagesCompressed = compressedIndexes(a.packagesCompressed)
+		arch.IndexFiles = append([]IndexFile{
+			hashedIndexFile(path, a.packagesFile),
+			a.packagesGz.indexFile(path),
+		}, compressedIndexFiles(path, a.packagesCompressed)...)
+	}
+
+	var releaseFile *WriteHasher
+	arch.ReleaseFile, releaseFile, err = r.writeIndexRelease(compName, archName)
+	if err != nil {
+		log.Printf("failed to create architecture release file, %v", err)
+	} else {
+		arch.IndexFiles = append(arch.IndexFiles, hashedIndexFile("binary-"+archName+"/Release", releaseFile))
+	}
+
+	contentsGz, err := r.writeContents(a.contents)
+	if err != nil {
+		log.Printf("failed to create contents index, %v", err)
+	} else {
+		arch.ContentsGz = contentsGz.id
+		arch.IndexFiles = append(arch.IndexFiles, contentsGz.indexFile("Contents-"+archName))
+	}
+
+	err = a.translationsStore.Close()
+	if err == nil {
+		arch.TranslationsPart, err = a.translationsStore.Identity()
+	}
+	if err != nil {
+		log.Printf("failed to store translations, %v", err)
+		return arch
+	}
+
+	// Only fully generated arches can be reused by later releases
+	if len(arch.PackagesGz) != 0 && len(arch.ReleaseFile) != 0 {
+		arch.Fingerprint = fingerprint
+	}
+
+	return arch
+}
+
+// mergeTranslations builds the Translation-en index of a component from
+// the translations of each of its architectures
+func (r *Release) mergeTranslations(comp *Component) error {
+	t, err := r.newTranslationTempData()
+	if err != nil {
+		return err
+	}
+
+	for _, arch := range comp.Architectures {
+		if len(arch.TranslationsPart) == 0 {
+			continue
+		}
+
+		err = r.readTranslations(t, arch.TranslationsPart)
+		if err != nil {
+			log.Printf("failed to read translations for %v, %v", arch.Name, err)
+		}
+	}
+
+	t.compressed = finishCompressedTempData(t.compressed)
+	err = t.gz.finish()
+	if err != nil {
+		return err
+	}
+
+	comp.TranslationsGz = t.gz.id
+	comp.TranslationsCompressed = compressedIndexes(t.compressed)
+	comp.TranslationsIndexFiles = append([]IndexFile{
+		t.gz.indexFile("i18n/Translation-en"),
+	}, compressedIndexFiles("i18n/Translation-en", t.compressed)...)
+
+	return nil
+}
+
+// readTranslations adds the translation paragraphs stored in id to the
+// translations being generated
+func (r *Release) readTranslations(t *translationTempData, id StoreID) error {
+	rdr, err := r.store.Open(id)
+	if err != nil {
+		return err
+	}
+	defer rdr.Close()
+
+	ctrl, err := ParseDebianControl(rdr, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, para := range ctrl.Data {
+		if _, ok := (*para)["Package"]; ok {
+			t.add(*para)
+		}
+	}
+
+	return nil
+}
+
+// reusableComponents returns the components of the parent release,
+// keyed by name. Indexes from the parent can only be reused if it was
+// built with the same configuration
+func (r *Release) reusableComponents() map[string]*Component {
+	comps := make(map[string]*Component)
+
+	p, err := r.Parent()
+	if err != nil || p.ConfigID.String() != r.ConfigID.String() {
+		return comps
+	}
+
+	for i := range p.Components {
+		comps[p.Components[i].Name] = &p.Components[i]
+	}
+
+	return comps
+}
+
+// findArch returns the named architecture of the component
+func (c *Component) findArch(archName string) (Architecture, bool) {
+	for _, arch := range c.Architectures {
+		if arch.Name == archName {
+			return arch, true
+		}
+	}
+	return Architecture{}, false
+}
+
 // updateReleaseSigFiles regenerates the Release, Packages and Sources files.
-// this needs breaking up a bit
+// Only the Packages and Sources indexes whose content has changed since the
+// parent release are regenerated, the rest are reused from the parent.
 func (r *Release) updateReleasefiles() {
 	// We want to merge all packages of arch all into each binary-$arch
 	// so we walk the package index in a first pass to find all the archs
-	// we are dealing with, we'll setup the temporary data to track the
-	// packages files while we are at it.
-	relMap := make(relTempData, 0)
-	archMap := make(map[string]bool, 0)
+	// we are dealing with. We fingerprint the items making up each index
+	// while we are at it, so we can tell which ones have changed.
+	var entries []ReleaseIndexEntry
+	compArchs := make(map[string]map[string]bool)
+	archMap := make(map[string]bool)
+	fingerprints := make(indexFingerprints)
+
 	preIndex, err := r.store.OpenReleaseIndex(r.IndexID)
 	if err != nil {
 		log.Printf("failed to update releases, %v", err)
@@ -230,48 +730,39 @@ func (r *Release) updateReleasefiles() {
 		if err != nil {
 			break
 		}
-		for _, b := range e.BinaryItems {
-			archName := b.Architecture
-			archMap[archName] = true
-
-			compName := b.Component
+		entries = append(entries, e)
 
-			comp, ok := relMap[compName]
-			if !ok {
-				relMap[compName] = &compTempData{}
-				comp = relMap[compName]
-				comp.sourcesFileWriter = MakeWriteHasher(ioutil.Discard)
-				comp.sourcesGzStore, err = r.store.Store()
-				if err != nil {
-					log.Printf("failed to update releases, %v", err)
-					return
-				}
-				comp.sourcesGzFile = MakeWriteHasher(comp.sourcesGzStore)
-				comp.sourcesGzFileWriter = gzip.NewWriter(comp.sourcesGzFile)
-				comp.sourcesWriter = io.MultiWriter(comp.sourcesFileWriter, comp.sourcesGzFileWriter)
-				comp.archs = make(map[string]*archTempData, 0)
+		if len(e.SourceItem.ControlID) != 0 {
+			if _, ok := compArchs[e.SourceItem.Component]; !ok {
+				compArchs[e.SourceItem.Component] = make(map[string]bool)
 			}
+			fingerprints.add(e.SourceItem.Component, "source", e.SourceItem)
+		}
 
-			arch, ok := comp.archs[archName]
-			if !ok {
-				// This is a new arch in this component
-				arch = new(archTempData)
-
-				arch.packagesFileWriter = MakeWriteHasher(ioutil.Discard)
-				arch.packagesGzStore, err = r.store.Store()
-				if err != nil {
-					log.Printf("failed to update releases, %v", err)
-				}
-				arch.packagesGzFile = MakeWriteHasher(arch.packagesGzStore)
-				arch.packagesGzFileWriter = gzip.NewWriter(arch.packagesGzFile)
-				arch.packagesWriter = io.MultiWriter(arch.packagesFileWriter, arch.packagesGzFileWriter)
-				comp.archs[archName] = arch
+		for _, b := range e.BinaryItems {
+			archMap[b.Architecture] = true
+			if _, ok := compArchs[b.Component]; !ok {
+				compArchs[b.Component] = make(map[string]bool)
 			}
+			compArchs[b.Component][b.Architecture] = true
+			fingerprints.add(b.Component, b.Architecture, b)
 		}
 	}
 
 	preIndex.Close()
 
+	cfg := r.Config()
+	if len(cfg.Architectures) != 0 {
+		for _, archName := range cfg.Architectures {
+			archMap[archName] = true
+		}
+		for _, compName := range cfg.AllowedComponents() {
+			if _, ok := compArchs[compName]; !ok {
+				compArchs[compName] = make(map[string]bool)
+			}
+		}
+	}
+
 	archNames := make(sort.StringSlice, 0)
 	for archName := range archMap {
 		if archName != "all" {
@@ -280,19 +771,64 @@ func (r *Release) updateReleasefiles() {
 	}
 	archNames.Sort()
 
-	//Now we'll walk the index again to collate the package lists
-	index, err := r.store.OpenReleaseIndex(r.IndexID)
-	if err != nil {
-		return
-	}
-	defer index.Close()
+	// Set up the temporary data for the indexes that have changed since
+	// the parent release. Packages of arch all are merged into every arch,
+	// unless they are kept separate, so components with arch all packages
+	// need every arch. Declared arches are published in every component,
+	// even if they are empty, so that clients do not fail to find them
+	fingerprintBase := r.CodeName + " " + r.Suite
+	prevComps := r.reusableComponents()
+	relMap := make(relTempData)
+	for compName, archs := range compArchs {
+		comp := &compTempData{
+			archs:              make(map[string]*archTempData),
+			archFingerprints:   make(map[string]string),
+			sourcesFingerprint: fingerprints.sum(fingerprintBase, compName, "source"),
+			prev:               prevComps[compName],
+		}
+		relMap[compName] = comp
 
-	for {
-		e, err := index.NextEntry()
-		if err != nil {
-			break
+		if comp.prev == nil || comp.prev.SourcesFingerprint != comp.sourcesFingerprint {
+			err = r.newSources(comp)
+			if err != nil {
+				log.Printf("failed to update releases, %v", err)
+				return
+			}
 		}
 
+		if len(cfg.Architectures) != 0 || (archs["all"] && !cfg.SeparateArchAll) {
+			for _, archName := range archNames {
+				archs[archName] = true
+			}
+		}
+		if cfg.SeparateArchAll {
+			archs["all"] = true
+		}
+
+		for archName := range archs {
+			fingerprint := fingerprints.sum(fingerprintBase, compName, archName, "all")
+			if archName == "all" || cfg.SeparateArchAll {
+				fingerprint = fingerprints.sum(fingerprintBase, compName, archName)
+			}
+			comp.archFingerprints[archName] = fingerprint
+
+			if comp.prev != nil {
+				if prevArch, ok := comp.prev.findArch(archName); ok && prevArch.Fingerprint == fingerprint {
+					continue
+				}
+			}
+
+			err = r.newArch(comp, archName)
+			if err != nil {
+				log.Printf("failed to update releases, %v", err)
+				return
+			}
+		}
+	}
+
+	// Now we'll walk the entries again to collate the package lists for
+	// the indexes being regenerated
+	for _, e := range entries {
 		s := e.SourceItem
 
 		srcName := s.Name
@@ -304,22 +840,25 @@ func (r *Release) updateReleasefiles() {
 		)
 
 		if len(s.ControlID) != 0 {
-			srcCtrl, err := r.store.GetControlFile(s.ControlID)
-			if err != nil {
-				log.Println("Could not retrieve control data, " + err.Error())
-				continue
-			}
-			srcCtrl.Data[0].SetValue("Directory", poolpath)
-			pkgStr, _ := srcCtrl.Data[0].GetValue("Source")
-			srcCtrl.Data[0].SetValue("Package", pkgStr)
 			srcComp, ok := relMap[s.Component]
 			if !ok {
 				log.Println("No Componenet for source item")
 				continue
 			}
 
-			FormatDpkgControlFile(srcComp.sourcesWriter, srcCtrl)
-			srcComp.sourcesWriter.Write([]byte("\n"))
+			if srcComp.sourcesWriter != nil {
+				srcCtrl, err := r.store.GetControlFile(s.ControlID)
+				if err != nil {
+					log.Println("Could not retrieve control data, " + err.Error())
+					continue
+				}
+				srcCtrl.Data[0].SetValue("Directory", poolpath)
+				pkgStr, _ := srcCtrl.Data[0].GetValue("Source")
+				srcCtrl.Data[0].SetValue("Package", pkgStr)
+
+				FormatDpkgControlFile(srcComp.sourcesWriter, srcCtrl)
+				srcComp.sourcesWriter.Write([]byte("\n"))
+			}
 		}
 
 		for _, b := range e.BinaryItems {
@@ -332,9 +871,21 @@ func (r *Release) updateReleasefiles() {
 				continue
 			}
 
+			// Find the Packages indexes being regenerated that this
+			// package appears in
+			var archs []*archTempData
 			arch, ok := comp.archs[archName]
-			if !ok {
-				log.Println("New file appeared in index!")
+			if ok {
+				archs = append(archs, arch)
+			}
+			if archName == "all" && !cfg.SeparateArchAll {
+				for _, otherArchName := range archNames {
+					if otherArch, ok := comp.archs[otherArchName]; ok {
+						archs = append(archs, otherArch)
+					}
+				}
+			}
+			if len(archs) == 0 {
 				continue
 			}
 
@@ -347,16 +898,27 @@ func (r *Release) updateReleasefiles() {
 			}
 			control.Data[0].SetValue("Filename", path)
 
-			FormatDpkgControlFile(arch.packagesWriter, control)
-			arch.packagesWriter.Write([]byte("\n"))
+			if trans, ok := translateDescription(*control.Data[0], cfg.StripLongDescriptions); ok && arch != nil {
+				arch.translations.add(trans)
+			}
 
-			if archName == "all" {
-				for _, otherArchName := range archNames {
-					otherArch, _ := comp.archs[otherArchName]
-					FormatDpkgControlFile(otherArch.packagesWriter, control)
-					otherArch.packagesWriter.Write([]byte("\n"))
+			var files []string
+			location := b.Name
+			if len(b.ContentsID) != 0 {
+				files, err = r.store.GetContents(b.ContentsID)
+				if err != nil {
+					log.Println("Could not retrieve contents data, " + err.Error())
+				}
+				if section, ok := control.Data[0].GetValues("Section"); ok {
+					location = *section[0] + "/" + b.Name
 				}
 			}
+
+			for _, a := range archs {
+				FormatDpkgControlFile(a.packagesWriter, control)
+				a.packagesWriter.Write([]byte("\n"))
+				a.contents.add(files, location)
+			}
 		}
 	}
 
@@ -370,111 +932,83 @@ func (r *Release) updateReleasefiles() {
 
 	for _, compName := range compNames {
 		c := relMap[compName]
+		comp := Component{Name: compName}
+
+		if c.sourcesWriter != nil {
+			r.finishSources(c, &comp)
+		} else {
+			comp.SourcesGz = c.prev.SourcesGz
+			comp.SourcesCompressed = c.prev.SourcesCompressed
+			comp.SourcesRelease = c.prev.SourcesRelease
+			comp.SourcesFingerprint = c.prev.SourcesFingerprint
+			comp.SourcesIndexFiles = c.prev.SourcesIndexFiles
+		}
 
-		c.sourcesSize = c.sourcesFileWriter.Count()
-		c.sourcesMD5 = hex.EncodeToString(c.sourcesFileWriter.MD5Sum())
-		c.sourcesSHA1 = hex.EncodeToString(c.sourcesFileWriter.SHA1Sum())
-		c.sourcesSHA256 = hex.EncodeToString(c.sourcesFileWriter.SHA256Sum())
-
-		c.sourcesGzFileWriter.Close()
-		c.sourcesGzStore.Close()
-		c.sourcesGzMD5 = hex.EncodeToString(c.sourcesGzFile.MD5Sum())
-		c.sourcesGzSHA1 = hex.EncodeToString(c.sourcesGzFile.SHA1Sum())
-		c.sourcesGzSHA256 = hex.EncodeToString(c.sourcesGzFile.SHA256Sum())
-		c.SourcesGzID, _ = c.sourcesGzStore.Identity()
-		c.sourcesGzSize, _ = r.store.Size(c.SourcesGzID)
+		compArchNames := make(sort.StringSlice, 0)
+		for archName := range c.archFingerprints {
+			compArchNames = append(compArchNames, archName)
+		}
+		compArchNames.Sort()
 
-		archsMap := c.archs
+		// The translations only need merging if the set of arches, or
+		// any of their content, has changed
+		archsChanged := c.prev == nil || len(c.prev.Architectures) != len(compArchNames)
+		for _, archName := range compArchNames {
+			archFiles, ok := c.archs[archName]
+			if !ok {
+				arch, _ := c.prev.findArch(archName)
+				comp.Architectures = append(comp.Architectures, arch)
+				continue
+			}
 
-		var archs []Architecture
-		archNames := make(sort.StringSlice, 0)
-		for archName := range archsMap {
-			archNames = append(archNames, archName)
+			archsChanged = true
+			comp.Architectures = append(comp.Architectures, r.finishArch(compName, archName, archFiles, c.archFingerprints[archName]))
 		}
-		archNames.Sort()
-
-		for _, archName := range archNames {
-			archFiles := archsMap[archName]
-			archFiles.packagesSize = archFiles.packagesFileWriter.Count()
-			archFiles.packagesMD5 = hex.EncodeToString(archFiles.packagesFileWriter.MD5Sum())
-			archFiles.packagesSHA1 = hex.EncodeToString(archFiles.packagesFileWriter.SHA1Sum())
-			archFiles.packagesSHA256 = hex.EncodeToString(archFiles.packagesFileWriter.SHA256Sum())
-
-			archFiles.packagesGzFileWriter.Close()
-			archFiles.packagesGzStore.Close()
-			archFiles.packagesGzMD5 = hex.EncodeToString(archFiles.packagesGzFile.MD5Sum())
-			archFiles.packagesGzSHA1 = hex.EncodeToString(archFiles.packagesGzFile.SHA1Sum())
-			archFiles.packagesGzSHA256 = hex.EncodeToString(archFiles.packagesGzFile.SHA256Sum())
-			archFiles.PackagesGzID, _ = archFiles.packagesGzStore.Identity()
-			archFiles.packagesGzSize, _ = r.store.Size(archFiles.PackagesGzID)
-
-			arch := Architecture{
-				Name:       archName,
-				PackagesGz: archFiles.PackagesGzID,
+
+		if archsChanged {
+			err = r.mergeTranslations(&comp)
+			if err != nil {
+				log.Printf("failed to create translations index, %v", err)
 			}
-			archs = append(archs, arch)
+		} else {
+			comp.TranslationsGz = c.prev.TranslationsGz
+			comp.TranslationsCompressed = c.prev.TranslationsCompressed
+			comp.TranslationsIndexFiles = c.prev.TranslationsIndexFiles
 		}
 
-		comp := Component{
-			Name:          compName,
-			Architectures: archs,
-			SourcesGz:     c.SourcesGzID,
-		}
 		r.Components = append(r.Components, comp)
 	}
 
 	releaseControl := ControlFile{}
 	para := MakeControlParagraph()
 
-	releaseStartFields := []string{"Origin", "Suite", "Codename"}
-	releaseEndFields := []string{"SHA256"}
-	para.SetValue("Origin", "GoDInstall")
+	releaseStartFields := []string{"Origin", "Label", "Suite", "Codename", "Date", "Valid-Until"}
+	releaseEndFields := []string{"Description", "MD5Sum", "SHA1", "SHA256", "SHA512"}
+	r.addReleaseMetadata(para)
 	para.SetValue("Suite", r.Suite)
 	para.SetValue("Codename", r.CodeName)
+	para.SetValue("Date", DebFormatTime(r.Date.UTC()))
+	para.SetValue("Acquire-By-Hash", "yes")
 
 	archNames = append(archNames, "all")
 	archNames.Sort()
 	para.SetValue("Architectures", strings.Join(archNames, " "))
 	para.SetValue("Components", strings.Join(compNames, " "))
-	para.AddValue("MD5Sum", "")
-
-	for i := range r.Components {
-		comp := r.Components[i]
-		c := relMap[comp.Name]
-		srcsLine := fmt.Sprintf("%v %d %v/source/Sources",
-			c.sourcesMD5,
-			c.sourcesSize,
-			comp.Name,
-		)
-		para.AddValue("MD5Sum", srcsLine)
-		srcsGzLine := fmt.Sprintf("%v %d %v/source/Sources.gz",
-			c.sourcesMD5,
-			c.sourcesSize,
-			comp.Name,
-		)
-		para.AddValue("MD5Sum", srcsGzLine)
-
-		for j := range r.Components[i].Architectures {
-			arch := comp.Architectures[j]
-			archFiles := relMap[comp.Name].archs[arch.Name]
+	if !cfg.SeparateArchAll {
+		para.SetValue("No-Support-for-Architecture-all", "Packages")
+	}
 
-			pkgsLine := fmt.Sprintf("%v %d %v/binary-%v/Packages",
-				archFiles.packagesMD5,
-				archFiles.packagesSize,
-				comp.Name,
-				arch.Name,
-			)
-			para.AddValue("MD5Sum", pkgsLine)
-			pkgsGzLine := fmt.Sprintf("%v %d %v/binary-%v/Packages.gz",
-				archFiles.packagesGzMD5,
-				archFiles.packagesGzSize,
-				comp.Name,
-				arch.Name,
-			)
-			para.AddValue("MD5Sum", pkgsGzLine)
+	var indexFiles []IndexFile
+	for _, comp := range r.Components {
+		indexFiles = append(indexFiles, prefixIndexFiles(comp.Name+"/", comp.SourcesIndexFiles)...)
+		indexFiles = append(indexFiles, prefixIndexFiles(comp.Name+"/", comp.TranslationsIndexFiles)...)
+		for _, arch := range comp.Architectures {
+			indexFiles = append(indexFiles, prefixIndexFiles(comp.Name+"/", arch.IndexFiles)...)
 		}
 	}
 
+	addReleaseIndexHashes(para, indexFiles)
+
 	releaseControl.Data = append(releaseControl.Data, &para)
 
 	releaseWriter, _ := r.store.Store()
@@ -485,6 +1019,101 @@ func (r *Release) updateReleasefiles() {
 	r.updateReleaseSigFiles()
 }
 
+// writeIndexRelease stores the Release file describing the indexes in the
+// directory of an architecture, or the sources, of a component
+func (r *Release) writeIndexRelease(compName, archName string) (StoreID, *WriteHasher, error) {
+	cfg := r.Config()
+
+	para := MakeControlParagraph()
+	para.SetValue("Archive", r.Suite)
+	para.SetValue("Origin", r.origin())
+	if cfg.Label != "" {
+		para.SetValue("Label", cfg.Label)
+	}
+	para.SetValue("Component", compName)
+	para.SetValue("Architecture", archName)
+
+	store, err := r.store.Store()
+	if err != nil {
+		return nil, nil, err
+	}
+	file := MakeWriteHasher(store)
+
+	WriteDebianControl(file, ControlFile{Data: []*ControlParagraph{&para}}, []string{"Archive", "Origin", "Label", "Component", "Architecture"}, nil)
+	err = store.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	id, err := store.Identity()
+	return id, file, err
+}
+
+// origin returns the Origin of the release files
+func (r *Release) origin() string {
+	if r.Config().Origin == "" {
+		return "GoDInstall"
+	}
+	return r.Config().Origin
+}
+
+// addReleaseMetadata adds the configured descriptive fields to the
+// release file paragraph
+func (r *Release) addReleaseMetadata(para ControlParagraph) {
+	cfg := r.Config()
+
+	para.SetValue("Origin", r.origin())
+
+	if cfg.Label != "" {
+		para.SetValue("Label", cfg.Label)
+	}
+
+	desc := cfg.Description
+	if desc == "" {
+		desc = r.Description
+	}
+	if desc != "" {
+		para.SetValue("Description", desc)
+	}
+
+	if validUntil := r.ValidUntil(); !validUntil.IsZero() {
+		para.SetValue("Valid-Until", DebFormatTime(validUntil.UTC()))
+	}
+
+	if cfg.NotAutomatic {
+		para.SetValue("NotAutomatic", "yes")
+		if cfg.ButAutomaticUpgrades {
+			para.SetValue("ButAutomaticUpgrades", "yes")
+		}
+	}
+}
+
+// ValidUntil returns the time at which the release files expire,
+// or the zero time if they do not expire
+func (r *Release) ValidUntil() time.Time {
+	d := r.Config().ValidDuration()
+	if d == 0 {
+		return time.Time{}
+	}
+	return r.Date.Add(d)
+}
+
+// IsRefresh returns true if the release only regenerated the release
+// files of its parent, trimming the history at most
+func (r *Release) IsRefresh() bool {
+	refresh := false
+	for _, a := range r.Actions {
+		switch a.Type {
+		case ActionREFRESH:
+			refresh = true
+		case ActionTRIM:
+		default:
+			return false
+		}
+	}
+	return refresh
+}
+
 // NewRelease creates a new release object in the specified store, based on the
 // parent and built using the passed in index, and associated set of
 // actions
@@ -493,8 +1122,20 @@ func NewRelease(store Archiver, parentid StoreID, indexid StoreID, actions []Rel
 	if err != nil {
 		return nil, err
 	}
+
+	return newChildRelease(store, parent, indexid, nil, actions)
+}
+
+// newChildRelease creates and stores a child of the parent release with the
+// given index and log actions. If configid is nil the child keeps the
+// configuration of the parent.
+func newChildRelease(store Archiver, parent *Release, indexid StoreID, configid StoreID, actions []ReleaseLogAction) (StoreID, error) {
 	release := parent.NewChild()
 	release.IndexID = indexid
+	if configid != nil {
+		release.ConfigID = configid
+		release.config = nil
+	}
 	release.Actions = actions
 
 	release.updateReleasefiles()
@@ -502,15 +1143,13 @@ func NewRelease(store Archiver, parentid StoreID, indexid StoreID, actions []Rel
 	// Trim the release history if requested
 	if release.Config().AutoTrim {
 		trimmer := release.Config().MakeTrimmer()
-		err = release.TrimHistory(store, trimmer)
+		err := release.TrimHistory(store, trimmer)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	releaseid, err := store.AddRelease(release)
-
-	return releaseid, nil
+	return store.AddRelease(release)
 }
 
 // PoolFilePath provides the full path to the location
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAddReleaseIndexHashes(t *testing.T) {
+	para := MakeControlParagraph()
+	addReleaseIndexHashes(para, []IndexFile{
+		{"main/source/Sources", 10, "a1", "b1", "c1", "d1"},
+		{"main/source/Sources.gz", 5, "a2", "b2", "c2", "d2"},
+	})
+
+	expected := map[string][]string{
+		"MD5Sum": {"", "a1 10 main/source/Sources", "a2 5 main/source/Sources.gz"},
+		"SHA1":   {"", "b1 10 main/source/Sources", "b2 5 main/source/Sources.gz"},
+		"SHA256": {"", "c1 10 main/source/Sources", "c2 5 main/source/Sources.gz"},
+		"SHA512": {"", "d1 10 main/source/Sources", "d2 5 main/source/Sources.gz"},
+	}
+
+	for field, lines := range expected {
+		vals, ok := para.GetValues(field)
+		if !ok {
+			t.Errorf("%v section missing", field)
+			continue
+		}
+		var got []string
+		for _, v := range vals {
+			got = append(got, *v)
+		}
+		if !reflect.DeepEqual(got, lines) {
+			t.Errorf("%v section incorrect, expected %v, got %v", field, lines, got)
+		}
+	}
+}
+
+func TestReleaseFileFields(t *testing.T) {
+	a, clean, err := makeTestArchive(t)
+	if err != nil {
+		return
+	}
+	defer clean()
+
+	rootid, err := a.GetReleaseRoot(Release{CodeName: "test", Suite: "test", Version: "0"})
+	if err != nil {
+		t.Fatalf("creating release root failed, %v", err)
+	}
+	emptyidx, _ := a.EmptyReleaseIndex()
+	relid, err := NewRelease(a, rootid, emptyidx, []ReleaseLogAction{})
+	if err != nil {
+		t.Fatalf("creating release failed, %v", err)
+	}
+	rel, _ := a.GetRelease(relid)
+
+	rdr, err := a.Open(rel.Release)
+	if err != nil {
+		t.Fatalf("opening release file failed, %v", err)
+	}
+	defer rdr.Close()
+
+	ctrl, err := ParseDebianControl(rdr, nil)
+	if err != nil {
+		t.Fatalf("parsing release file failed, %v", err)
+	}
+
+	para := ctrl.Data[0]
+	dateStr, ok := para.GetValues("Date")
+	if !ok {
+		t.Fatalf("Date field missing")
+	}
+	date, err := ParseDebianDate(*dateStr[0])
+	if err != nil {
+		t.Errorf("invalid Date field, %v", err)
+	}
+	if !date.Equal(rel.Date.Truncate(time.Second)) {
+		t.Errorf("Date field %v does not match release date %v", date, rel.Date)
+	}
+	if !strings.HasSuffix(*dateStr[0], "+0000") {
+		t.Errorf("Date field should be in UTC, got %v", *dateStr[0])
+	}
+
+	for _, field := range []string{"MD5Sum", "SHA1", "SHA256", "SHA512"} {
+		if _, ok := para.GetValues(field); !ok {
+			t.Errorf("%v section missing", field)
+		}
+	}
+}
+
+func TestReleaseComponents(t *testing.T) {
+	a, clean, err := makeTestArchive(t)
+	if err != nil {
+		return
+	}
+	defer clean()
+
+	entries := []*ReleaseIndexEntry{
+		{
+			SourceItem:  ReleaseIndexEntryItem{Name: "pkga", Version: DebVersion{0, "1", "1"}, Architecture: "source", Component: "main"},
+			BinaryItems: []ReleaseIndexEntryItem{makeTestBinaryItem(t, a, "pkga", DebVersion{0, "1", "1"}, "amd64", "main", "")},
+		},
+		{
+			SourceItem:  ReleaseIndexEntryItem{Name: "pkgb", Version: DebVersion{0, "1", "1"}, Architecture: "source", Component: "contrib"},
+			BinaryItems: []ReleaseIndexEntryItem{makeTestBinaryItem(t, a, "pkgb", DebVersion{0, "1", "1"}, "all", "contrib", "")},
+		},
+		{
+			SourceItem: ReleaseIndexEntryItem{
+				Name:         "pkgc",
+				Version:      DebVersion{0, "1", "1"},
+				Architecture: "source",
+				Component:    "non-free",
+				ControlID:    makeTestControl(t, a, "Source: pkgc\nVersion: 1-1\n"),
+				Files:        []ReleaseIndexEntryItemFile{{Name: "pkgc_1-1.dsc"}},
+			},
+		},
+	}
+
+	relid := makeTestRelease(t, a, "test", entries)
+	rel, _ := a.GetRelease(relid)
+	rel.updateReleasefiles()
+
+	comps := map[string][]string{}
+	var names []string
+	for _, c := range rel.Components {
+		names = append(names, c.Name)
+		comps[c.Name] = []string{}
+		for _, arch := range c.Architectures {
+			comps[c.Name] = append(comps[c.Name], arch.Name)
+		}
+	}
+
+	expected := map[string][]string{
+		"contrib":  {"all", "amd64"},
+		"main":     {"amd64"},
+		"non-free": {},
+	}
+	if !reflect.DeepEqual(comps, expected) {
+		t.Errorf("expected components %v, got %v", expected, comps)
+	}
+	if !reflect.DeepEqual(names, []string{"contrib", "main", "non-free"}) {
+		t.Errorf("components not sorted, got %v", names)
+	}
+}
+
+func TestIncrementalReleaseFiles(t *testing.T) {
+	a, clean, err := makeTestArchive(t)
+	if err != nil {
+		return
+	}
+	defer clean()
+
+	entry := func(name, rev, arch, comp string) *ReleaseIndexEntry {
+		version := DebVersion{0, "1", rev}
+		return &ReleaseIndexEntry{
+			SourceItem: ReleaseIndexEntryItem{
+				Name:         name,
+				Version:      version,
+				Architecture: "source",
+				Component:    comp,
+				ControlID:    makeTestControl(t, a, "Source: "+name+"\nVersion: "+version.String()+"\n"),
+				Files:        []ReleaseIndexEntryItemFile{{Name: name + "_" + version.String() + ".dsc"}},
+			},
+			BinaryItems: []ReleaseIndexEntryItem{
+				makeTestBinaryItem(t, a, name, version, arch, comp, "Description: "+name+"\n long "+rev+"\n"),
+			},
+		}
+	}
+
+	pkgb := entry("pkgb", "1", "all", "contrib")
+	pkgc := entry("pkgc", "1", "i386", "main")
+
+	// Build the first release from scratch
+	firstIdx := makeTestRelease(t, a, "test", []*ReleaseIndexEntry{
+		entry("pkga", "1", "amd64", "main"), pkgb, pkgc,
+	})
+	firstRel, _ := a.GetRelease(firstIdx)
+	firstid, err := NewRelease(a, firstIdx, firstRel.IndexID, nil)
+	if err != nil {
+		t.Fatalf("creating release failed, %v", err)
+	}
+	first, _ := a.GetRelease(firstid)
+	if p, err := first.Parent(); err != nil || p.id.String() != firstIdx.String() {
+		t.Fatalf("parent of a stored release should be its ParentID, got %v", p)
+	}
+
+	// Build the updated index from scratch, for comparison
+	secondIdx := makeTestRelease(t, a, "test", []*ReleaseIndexEntry{
+		entry("pkga", "2", "amd64", "main"), pkgb, pkgc,
+	})
+	secondRel, _ := a.GetRelease(secondIdx)
+	fullid, err := NewRelease(a, secondIdx, secondRel.IndexID, nil)
+	if err != nil {
+		t.Fatalf("creating release failed, %v", err)
+	}
+	full, _ := a.GetRelease(fullid)
+
+	// The unchanged packages should not be needed to build the
+	// incremental release
+	a.UnLink(pkgb.BinaryItems[0].ControlID)
+	a.UnLink(pkgc.BinaryItems[0].ControlID)
+
+	secondid, err := NewRelease(a, firstid, secondRel.IndexID, nil)
+	if err != nil {
+		t.Fatalf("creating release failed, %v", err)
+	}
+	second, _ := a.GetRelease(secondid)
+
+	changed := map[string]bool{}
+	for i, comp := range second.Components {
+		prev := first.Components[i]
+		if comp.SourcesGz.String() != prev.SourcesGz.String() {
+			changed[comp.Name+"/source"] = true
+		}
+		for j, arch := range comp.Architectures {
+			if arch.PackagesGz.String() != prev.Architectures[j].PackagesGz.String() {
+				changed[comp.Name+"/"+arch.Name] = true
+			}
+		}
+	}
+
+	expected := map[string]bool{"main/source": true, "main/amd64": true}
+	if !reflect.DeepEqual(changed, expected) {
+		t.Errorf("expected changed indexes %v, got %v", expected, changed)
+	}
+
+	if !reflect.DeepEqual(full.Components, second.Components) {
+		t.Errorf("incremental release differs from full release, expected %v, got %v", full.Components, second.Components)
+	}
+}
+
+func TestReleaseArchitectures(t *testing.T) {
+	a, clean, err := makeTestArchive(t)
+	if err != nil {
+		return
+	}
+	defer clean()
+
+	read := func(id StoreID, gz bool) string {
+		rdr, err := a.Open(id)
+		if err != nil {
+			t.Fatalf("opening %v failed, %v", id, err)
+		}
+		defer rdr.Close()
+		if gz {
+			gunzipper, err := gzip.NewReader(rdr)
+			if err != nil {
+				t.Fatalf("opening %v failed, %v", id, err)
+			}
+			defer gunzipper.Close()
+			data, _ := ioutil.ReadAll(gunzipper)
+			return string(data)
+		}
+		data, _ := ioutil.ReadAll(rdr)
+		return string(data)
+	}
+
+	relid := makeTestRelease(t, a, "test", []*ReleaseIndexEntry{
+		{
+			SourceItem:  ReleaseIndexEntryItem{Name: "pkga", Version: DebVersion{0, "1", "1"}, Architecture: "source", Component: "main"},
+			BinaryItems: []ReleaseIndexEntryItem{makeTestBinaryItem(t, a, "pkga", DebVersion{0, "1", "1"}, "amd64", "main", "")},
+		},
+		{
+			SourceItem:  ReleaseIndexEntryItem{Name: "pkgb", Version: DebVersion{0, "1", "1"}, Architecture: "source", Component: "contrib"},
+			BinaryItems: []ReleaseIndexEntryItem{makeTestBinaryItem(t, a, "pkgb", DebVersion{0, "1", "1"}, "all", "contrib", "")},
+		},
+	})
+
+	tests := []struct {
+		separate bool
+		expected map[string][]string
+	}{
+		{false, map[string][]string{"contrib": {"all", "amd64", "arm64"}, "main": {"amd64", "arm64"}}},
+		{true, map[string][]string{"contrib": {"all", "amd64", "arm64"}, "main": {"all", "amd64", "arm64"}}},
+	}
+
+	for _, test := range tests {
+		rel, _ := a.GetRelease(relid)
+		cfg := *rel.Config()
+		cfg.Architectures = []string{"amd64", "arm64"}
+		cfg.SeparateArchAll = test.separate
+		rel.ConfigID, _ = a.AddReleaseConfig(cfg)
+		rel.config = nil
+		rel.updateReleasefiles()
+
+		comps := map[string][]string{}
+		archs := map[string]Architecture{}
+		for _, c := range rel.Components {
+			for _, arch := range c.Architectures {
+				comps[c.Name] = append(comps[c.Name], arch.Name)
+				archs[c.Name+"/"+arch.Name] = arch
+			}
+		}
+		if !reflect.DeepEqual(comps, test.expected) {
+			t.Errorf("separate %v, expected components %v, got %v", test.separate, test.expected, comps)
+			continue
+		}
+
+		if pkgs := read(archs["main/arm64"].PackagesGz, true); pkgs != "" {
+			t.Errorf("separate %v, expected empty Packages for main/arm64, got %q", test.separate, pkgs)
+		}
+
+		merged := strings.Contains(read(archs["contrib/amd64"].PackagesGz, true), "Package: pkgb\n")
+		if merged == test.separate {
+			t.Errorf("separate %v, arch all package merged into contrib/amd64 %v", test.separate, merged)
+		}
+
+		archRelease := read(archs["main/arm64"].ReleaseFile, false)
+		if archRelease != "Archive: test\nOrigin: GoDInstall\nComponent: main\nArchitecture: arm64\n" {
+			t.Errorf("separate %v, incorrect architecture Release file %q", test.separate, archRelease)
+		}
+
+		release := read(rel.Release, false)
+		if !strings.Contains(release, " main/binary-arm64/Release\n") {
+			t.Errorf("separate %v, architecture Release file not listed", test.separate)
+		}
+		if strings.Contains(release, "No-Support-for-Architecture-all: Packages\n") == test.separate {
+			t.Errorf("separate %v, incorrect No-Support-for-Architecture-all in %q", test.separate, release)
+		}
+	}
+
+	cfg := ReleaseConfig{Architectures: []string{"amd64"}}
+	for arch, allowed := range map[string]bool{"amd64": true, "all": true, "source": true, "i386": false} {
+		if cfg.AllowedArchitecture(arch) != allowed {
+			t.Errorf("expected AllowedArchitecture(%v) to be %v", arch, allowed)
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"regexp"
+	"strings"
+	"time"
 )
 
 // ReleaseConfig is used for configuration options which can be set and managed
@@ -12,15 +15,34 @@ type ReleaseConfig struct {
 	VerifyChanges           bool
 	VerifyChangesSufficient bool
 	VerifyDebs              bool
+	VerifyDscs              bool
 	AcceptLoneDebs          bool
 
 	PoolPattern string
 
 	AutoTrim       bool
-	AutoTrimLength int
+	AutoTrimLength int    // Number of releases to retain, 0 for no limit if AutoTrimAge is set
+	AutoTrimAge    string // Age of releases to retain, e.g. 90d, empty for no limit
 
 	PruneRules string
 
+	IndexCompressions []string // Additional compressions (xz, bz2) of the package indexes
+	ByHashGenerations int      // Number of previous releases to keep the by-hash indexes of
+
+	StripLongDescriptions bool // Only publish long descriptions in the Translation-en index
+
+	Components []string // Components packages may be added to, main if empty
+
+	Architectures   []string // Architectures packages may be added for, any if empty
+	SeparateArchAll bool     // Only list Architecture: all packages in binary-all, rather than in every architecture
+
+	Origin               string // Origin of the Release file, GoDInstall if empty
+	Label                string // Label of the Release file, omitted if empty
+	Description          string // Description of the Release file, omitted if empty
+	ValidFor             string // How long a Release file is valid for, e.g. 7d, empty for no Valid-Until
+	NotAutomatic         bool   // Packages are not installed automatically
+	ButAutomaticUpgrades bool   // Installed packages are upgraded automatically, requires NotAutomatic
+
 	PublicKeyIDs []StoreID `json:",omitempty"`
 	SigningKeyID StoreID   `json:",omitempty"`
 
@@ -31,7 +53,155 @@ type ReleaseConfig struct {
 // MakeTrimmer returns a trimmer that will implement the
 // trimming configuration
 func (r *ReleaseConfig) MakeTrimmer() Trimmer {
-	return MakeLengthTrimmer(r.AutoTrimLength)
+	trimmers := []Trimmer{}
+
+	if r.AutoTrimAge != "" {
+		age, err := ParseAge(r.AutoTrimAge)
+		if err != nil {
+			log.Println("Error parsing stored trim age", err)
+		} else {
+			trimmers = append(trimmers, MakeTimeTrimmer(age))
+		}
+	}
+
+	// With an age limit, a length of 0 leaves the number of releases unlimited
+	if r.AutoTrimLength != 0 || len(trimmers) == 0 {
+		trimmers = append(trimmers, MakeLengthTrimmer(r.AutoTrimLength))
+	}
+
+	return MakeCombinedTrimmer(trimmers...)
+}
+
+// AllowedComponents returns the list of components packages may
+// be added to
+func (r *ReleaseConfig) AllowedComponents() []string {
+	if len(r.Components) == 0 {
+		return []string{"main"}
+	}
+	return r.Components
+}
+
+// ValidDuration returns how long Release files are valid for, or 0
+// if they do not expire
+func (r *ReleaseConfig) ValidDuration() time.Duration {
+	if r.ValidFor == "" {
+		return 0
+	}
+
+	d, err := ParseAge(r.ValidFor)
+	if err != nil {
+		log.Println("Error parsing stored valid duration", err)
+		return 0
+	}
+
+	return d
+}
+
+// CheckValidFor checks that Release files that are valid for the given
+// duration will be refreshed, every refreshInterval, before they expire
+func CheckValidFor(validFor string, refreshInterval time.Duration) error {
+	d, err := ParseAge(validFor)
+	if err != nil {
+		return err
+	}
+
+	if refreshInterval <= 0 {
+		log.Printf("Release files valid for %v will not be refreshed, refresh-interval is disabled", validFor)
+		return nil
+	}
+
+	if d < 2*refreshInterval {
+		return fmt.Errorf("ValidFor must be at least twice the refresh interval of %v", refreshInterval)
+	}
+
+	return nil
+}
+
+// ParseComponents parses a comma seperated list of components
+func ParseComponents(str string) ([]string, error) {
+	comps := []string{}
+	for _, c := range strings.Split(str, ",") {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		comps = append(comps, c)
+	}
+
+	return comps, CheckComponents(comps)
+}
+
+// CheckComponents checks that a list of component names is valid
+func CheckComponents(comps []string) error {
+	seen := map[string]bool{}
+	for _, c := range comps {
+		if c == "" || strings.ContainsAny(c, "/ \t,") {
+			return fmt.Errorf("invalid component name \"%v\"", c)
+		}
+		if seen[c] {
+			return fmt.Errorf("component %v given more than once", c)
+		}
+		seen[c] = true
+	}
+	return nil
+}
+
+// AllowedComponent returns true if packages may be added to the
+// named component
+func (r *ReleaseConfig) AllowedComponent(comp string) bool {
+	for _, c := range r.AllowedComponents() {
+		if c == comp {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseArchitectures parses a comma seperated list of architectures
+func ParseArchitectures(str string) ([]string, error) {
+	archs := []string{}
+	for _, a := range strings.Split(str, ",") {
+		a = strings.TrimSpace(a)
+		if a == "" {
+			continue
+		}
+		archs = append(archs, a)
+	}
+
+	return archs, CheckArchitectures(archs)
+}
+
+// CheckArchitectures checks that a list of architecture names is valid.
+// all and source are always allowed, so cannot be listed
+func CheckArchitectures(archs []string) error {
+	seen := map[string]bool{}
+	for _, a := range archs {
+		if a == "" || strings.ContainsAny(a, "/ \t,_") {
+			return fmt.Errorf("invalid architecture name \"%v\"", a)
+		}
+		if a == "all" || a == "source" {
+			return fmt.Errorf("architecture %v is always allowed, and cannot be listed", a)
+		}
+		if seen[a] {
+			return fmt.Errorf("architecture %v given more than once", a)
+		}
+		seen[a] = true
+	}
+	return nil
+}
+
+// AllowedArchitecture returns true if packages may be added for the
+// named architecture
+func (r *ReleaseConfig) AllowedArchitecture(arch string) bool {
+	if len(r.Architectures) == 0 || arch == "all" || arch == "source" {
+		return true
+	}
+	for _, a := range r.Architectures {
+		if a == arch {
+			return true
+		}
+	}
+	return false
 }
 
 // MakePruner returns a pruner that will implement
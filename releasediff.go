@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+)
+
+// ReleaseDiffItem describes a change to a single source or binary item
+// between two releases
+type ReleaseDiffItem struct {
+	Change       string // One of added, removed or changed
+	Source       string // The source package the item belongs to
+	Name         string
+	Version      string
+	Architecture string
+	Component    string
+}
+
+// ReleaseDiffConfig describes a change to a configuration setting
+// between two releases
+type ReleaseDiffConfig struct {
+	Field string
+	From  json.RawMessage
+	To    json.RawMessage
+}
+
+// ReleaseDiff describes the differences between two releases
+type ReleaseDiff struct {
+	From   StoreID
+	To     StoreID
+	Items  []ReleaseDiffItem
+	Config []ReleaseDiffConfig
+}
+
+// DiffReleases compares the indexes and configuration of two releases
+func DiffReleases(store ArchiveStorer, fromid StoreID, toid StoreID) (*ReleaseDiff, error) {
+	from, err := store.GetRelease(fromid)
+	if err != nil {
+		return nil, errors.New("error getting release, " + err.Error())
+	}
+	to, err := store.GetRelease(toid)
+	if err != nil {
+		return nil, errors.New("error getting release, " + err.Error())
+	}
+
+	diff := &ReleaseDiff{
+		From:   fromid,
+		To:     toid,
+		Items:  []ReleaseDiffItem{},
+		Config: diffReleaseConfigs(from.Config(), to.Config()),
+	}
+
+	fromidx, err := store.OpenReleaseIndex(from.IndexID)
+	if err != nil {
+		return nil, errors.New("error opening release index, " + err.Error())
+	}
+	defer fromidx.Close()
+
+	toidx, err := store.OpenReleaseIndex(to.IndexID)
+	if err != nil {
+		return nil, errors.New("error opening release index, " + err.Error())
+	}
+	defer toidx.Close()
+
+	// Both indexes are in ReleaseIndexEntryOrder, so we can walk
+	// them side by side
+	left, lerr := fromidx.NextEntry()
+	right, rerr := toidx.NextEntry()
+
+	for lerr == nil || rerr == nil {
+		cmp := 0
+		switch {
+		case rerr != nil:
+			cmp = -1
+		case lerr != nil:
+			cmp = 1
+		default:
+			cmp = ReleaseIndexEntryOrder(&left, &right)
+		}
+
+		switch {
+		case cmp < 0:
+			diff.addEntry("removed", &left)
+			left, lerr = fromidx.NextEntry()
+		case cmp > 0:
+			diff.addEntry("added", &right)
+			right, rerr = toidx.NextEntry()
+		default:
+			diff.compareEntries(&left, &right)
+			left, lerr = fromidx.NextEntry()
+			right, rerr = toidx.NextEntry()
+		}
+	}
+
+	return diff, nil
+}
+
+func (d *ReleaseDiff) addItem(change string, source string, item *ReleaseIndexEntryItem) {
+	d.Items = append(d.Items, ReleaseDiffItem{
+		Change:       change,
+		Source:       source,
+		Name:         item.Name,
+		Version:      item.Version.String(),
+		Architecture: item.Architecture,
+		Component:    item.Component,
+	})
+}
+
+// addEntry records every item in an entry as having the given change
+func (d *ReleaseDiff) addEntry(change string, e *ReleaseIndexEntry) {
+	src := e.SourceItem.Name
+	if len(e.SourceItem.Files) > 0 {
+		d.addItem(change, src, &e.SourceItem)
+	}
+	for i := range e.BinaryItems {
+		d.addItem(change, src, &e.BinaryItems[i])
+	}
+}
+
+// compareEntries records the differences between two entries for the
+// same source package and version
+func (d *ReleaseDiff) compareEntries(from, to *ReleaseIndexEntry) {
+	src := to.SourceItem.Name
+
+	switch {
+	case len(from.SourceItem.Files) == 0 && len(to.SourceItem.Files) > 0:
+		d.addItem("added", src, &to.SourceItem)
+	case len(from.SourceItem.Files) > 0 && len(to.SourceItem.Files) == 0:
+		d.addItem("removed", src, &from.SourceItem)
+	case !sameReleaseIndexEntryItem(&from.SourceItem, &to.SourceItem):
+		d.addItem("changed", src, &to.SourceItem)
+	}
+
+	key := func(i *ReleaseIndexEntryItem) string {
+		return i.Name + "_" + i.Architecture
+	}
+
+	fromBins := make(map[string]*ReleaseIndexEntryItem)
+	for i := range from.BinaryItems {
+		fromBins[key(&from.BinaryItems[i])] = &from.BinaryItems[i]
+	}
+
+	for i := range to.BinaryItems {
+		b := &to.BinaryItems[i]
+		old, ok := fromBins[key(b)]
+		switch {
+		case !ok:
+			d.addItem("added", src, b)
+		case !sameReleaseIndexEntryItem(old, b):
+			d.addItem("changed", src, b)
+		}
+		delete(fromBins, key(b))
+	}
+
+	for i := range from.BinaryItems {
+		b := &from.BinaryItems[i]
+		if _, ok := fromBins[key(b)]; ok {
+			d.addItem("removed", src, b)
+		}
+	}
+}
+
+// sameReleaseIndexEntryItem returns true if both items have the same control
+// data and files
+func sameReleaseIndexEntryItem(a, b *ReleaseIndexEntryItem) bool {
+	if a.Component != b.Component ||
+		!bytes.Equal(a.ControlID, b.ControlID) ||
+		len(a.Files) != len(b.Files) {
+		return false
+	}
+
+	for i := range a.Files {
+		if a.Files[i].Name != b.Files[i].Name ||
+			!bytes.Equal(a.Files[i].StoreID, b.Files[i].StoreID) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// diffReleaseConfigs lists the exported settings that differ between two
+// release configurations
+func diffReleaseConfigs(from, to *ReleaseConfig) []ReleaseDiffConfig {
+	res := []ReleaseDiffConfig{}
+
+	fv := reflect.ValueOf(*from)
+	tv := reflect.ValueOf(*to)
+	t := fv.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			// Unexported, used for caching parsed settings
+			continue
+		}
+
+		fj, _ := json.Marshal(fv.Field(i).Interface())
+		tj, _ := json.Marshal(tv.Field(i).Interface())
+		if !bytes.Equal(fj, tj) {
+			res = append(res, ReleaseDiffConfig{
+				Field: field.Name,
+				From:  fj,
+				To:    tj,
+			})
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+var testDiffFrom = []*ReleaseIndexEntry{
+	&ReleaseIndexEntry{
+		SourceItem: ReleaseIndexEntryItem{Name: "pkga", Version: DebVersion{0, "1", "1"}},
+		BinaryItems: []ReleaseIndexEntryItem{
+			ReleaseIndexEntryItem{Name: "pkga", Version: DebVersion{0, "1", "1"}, Architecture: "amd64", ControlID: StoreID("a1")},
+		},
+	},
+	&ReleaseIndexEntry{
+		SourceItem: ReleaseIndexEntryItem{Name: "pkgb", Version: DebVersion{0, "1", "1"}},
+		BinaryItems: []ReleaseIndexEntryItem{
+			ReleaseIndexEntryItem{Name: "pkgb", Version: DebVersion{0, "1", "1"}, Architecture: "amd64", ControlID: StoreID("b1")},
+			ReleaseIndexEntryItem{Name: "pkgb", Version: DebVersion{0, "1", "1"}, Architecture: "i386", ControlID: StoreID("b2")},
+		},
+	},
+}
+
+var testDiffTo = []*ReleaseIndexEntry{
+	&ReleaseIndexEntry{
+		SourceItem: ReleaseIndexEntryItem{Name: "pkga", Version: DebVersion{0, "2", "1"}},
+		BinaryItems: []ReleaseIndexEntryItem{
+			ReleaseIndexEntryItem{Name: "pkga", Version: DebVersion{0, "2", "1"}, Architecture: "amd64", ControlID: StoreID("a2")},
+		},
+	},
+	&ReleaseIndexEntry{
+		SourceItem: ReleaseIndexEntryItem{Name: "pkga", Version: DebVersion{0, "1", "1"}},
+		BinaryItems: []ReleaseIndexEntryItem{
+			ReleaseIndexEntryItem{Name: "pkga", Version: DebVersion{0, "1", "1"}, Architecture: "amd64", ControlID: StoreID("a1")},
+		},
+	},
+	&ReleaseIndexEntry{
+		SourceItem: ReleaseIndexEntryItem{Name: "pkgb", Version: DebVersion{0, "1", "1"}},
+		BinaryItems: []ReleaseIndexEntryItem{
+			ReleaseIndexEntryItem{Name: "pkgb", Version: DebVersion{0, "1", "1"}, Architecture: "amd64", ControlID: StoreID("b3")},
+		},
+	},
+}
+
+var testDiffResult = []ReleaseDiffItem{
+	{"added", "pkga", "pkga", "2-1", "amd64", ""},
+	{"changed", "pkgb", "pkgb", "1-1", "amd64", ""},
+	{"removed", "pkgb", "pkgb", "1-1", "i386", ""},
+}
+
+func TestDiffReleases(t *testing.T) {
+	a, clean, err := makeTestArchive(t)
+	if err != nil {
+		return
+	}
+	defer clean()
+
+	fromid := makeTestRelease(t, a, "from", testDiffFrom)
+	toid := makeTestRelease(t, a, "to", testDiffTo)
+
+	to, _ := a.GetRelease(toid)
+	cfg := *to.Config()
+	cfg.AcceptLoneDebs = !cfg.AcceptLoneDebs
+	to.ConfigID, _ = a.AddReleaseConfig(cfg)
+	toid, _ = a.AddRelease(to)
+
+	diff, err := DiffReleases(a, fromid, toid)
+	if err != nil {
+		t.Fatalf("diff failed, %v", err)
+	}
+
+	if !reflect.DeepEqual(diff.Items, testDiffResult) {
+		t.Errorf("diff failed\nExpected:\n%v\nGot:\n%v\n", testDiffResult, diff.Items)
+	}
+
+	if len(diff.Config) != 1 || diff.Config[0].Field != "AcceptLoneDebs" {
+		t.Errorf("config diff failed, got %v", diff.Config)
+	}
+
+	diff, _ = DiffReleases(a, toid, toid)
+	if len(diff.Items) != 0 || len(diff.Config) != 0 {
+		t.Errorf("diff of a release with itself should be empty, got %v", diff)
+	}
+}
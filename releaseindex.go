@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"time"
 )
 
 // ReleaseIndexEntryItemFile repesent one file that makes up part of an
@@ -31,6 +32,7 @@ type ReleaseIndexEntryItem struct {
 	Architecture string
 	Component    string
 	ControlID    StoreID                     // StoreID for the control data
+	ContentsID   StoreID                     // StoreID for the list of files in a binary package
 	Files        []ReleaseIndexEntryItemFile // This list of files that make up this item
 }
 
@@ -39,7 +41,8 @@ type ReleaseIndexEntryItem struct {
 type ReleaseIndexEntry struct {
 	SourceItem  ReleaseIndexEntryItem
 	BinaryItems []ReleaseIndexEntryItem
-	ChangesID   StoreID // StoreID for the changes data
+	ChangesID   StoreID   // StoreID for the changes data
+	UploadDate  time.Time // Date from the changes file, zero for older entries
 }
 
 // NewReleaseIndexEntry  turns an UploadSession (a collection of hash verified
@@ -50,7 +53,6 @@ func NewReleaseIndexEntry(u *UploadSession) (*ReleaseIndexEntry, error) {
 		Name:         u.changes.Source,
 		Version:      u.changes.SourceVersion,
 		Architecture: "source",
-		Component:    "main",
 	}
 
 	srcFiles := []ReleaseIndexEntryItemFile{}
@@ -62,6 +64,9 @@ func NewReleaseIndexEntry(u *UploadSession) (*ReleaseIndexEntry, error) {
 			Name:     f.Name,
 			StoreID:  f.storeID,
 			Size:     f.Size,
+			Md5:      f.md5,
+			Sha1:     f.sha1,
+			Sha256:   f.sha256,
 			SignedBy: f.SignedBy,
 		}
 		switch {
@@ -76,7 +81,10 @@ func NewReleaseIndexEntry(u *UploadSession) (*ReleaseIndexEntry, error) {
 			}
 			uv, _ := f.pkg.Version()
 			ua, _ := f.pkg.Architecture()
-			uc := "main"
+			uc, err := u.fileComponent(f.Name)
+			if err != nil {
+				return nil, err
+			}
 			ui := f.controlID
 
 			if uv != binVersion {
@@ -94,11 +102,17 @@ func NewReleaseIndexEntry(u *UploadSession) (*ReleaseIndexEntry, error) {
 				Architecture: ua,
 				Component:    uc,
 				ControlID:    ui,
+				ContentsID:   f.contentsID,
 				Files:        []ReleaseIndexEntryItemFile{rief},
 			})
 		case strings.HasSuffix(f.Name, ".dsc"):
+			sc, err := u.fileComponent(f.Name)
+			if err != nil {
+				return nil, err
+			}
 			srcFiles = append(srcFiles, rief)
 			srcItem.ControlID = f.controlID
+			srcItem.Component = sc
 		default:
 			srcFiles = append(srcFiles, rief)
 		}
@@ -106,10 +120,23 @@ func NewReleaseIndexEntry(u *UploadSession) (*ReleaseIndexEntry, error) {
 
 	srcItem.Files = srcFiles
 
+	// Binary only uploads take the component of their binaries
+	if srcItem.Component == "" {
+		srcItem.Component = "main"
+		first := ""
+		for _, b := range binItems {
+			if first == "" || b.Name < first {
+				first = b.Name
+				srcItem.Component = b.Component
+			}
+		}
+	}
+
 	return &ReleaseIndexEntry{
 		SourceItem:  srcItem,
 		BinaryItems: binItems,
 		ChangesID:   u.changesID,
+		UploadDate:  u.changes.Date,
 	}, nil
 }
 
@@ -194,6 +221,28 @@ type ReleaseIndexReader interface {
 	Close() error
 }
 
+// findEntry searches the given index for the entry for a given source
+// package and version
+func (a archiveStoreArchive) findEntry(indexid StoreID, name string, version DebVersion) (*ReleaseIndexEntry, error) {
+	index, err := a.OpenReleaseIndex(indexid)
+	if err != nil {
+		return nil, errors.New("error opening index, " + err.Error())
+	}
+	defer index.Close()
+
+	for {
+		entry, err := index.NextEntry()
+		if err != nil {
+			return nil, ErrPackageNotFound
+		}
+
+		if entry.SourceItem.Name == name &&
+			DebVersionCompare(entry.SourceItem.Version, version) == 0 {
+			return &entry, nil
+		}
+	}
+}
+
 // Merge the content of index into the parent commit and return a new index
 func (a archiveStoreArchive) mergeEntryIntoRelease(parentid StoreID, entry *ReleaseIndexEntry) (result StoreID, actions []ReleaseLogAction, err error) {
 	parent, err := a.GetRelease(parentid)
@@ -307,3 +356,131 @@ func (a archiveStoreArchive) mergeEntryIntoRelease(parentid StoreID, entry *Rele
 	id, err := mergedidx.Close()
 	return StoreID(id), actions, err
 }
+
+// Remove a source package, or the items of one architecture of a package,
+// from the index of the parent commit and return a new index
+func (a archiveStoreArchive) removeEntryFromRelease(parentid StoreID, name string, version DebVersion, arch string, who string) (result StoreID, actions []ReleaseLogAction, err error) {
+	parent, err := a.GetRelease(parentid)
+	actions = make([]ReleaseLogAction, 0)
+	if err != nil {
+		return nil, actions, errors.New("error getting parent commit, " + err.Error())
+	}
+
+	parentidx, err := a.OpenReleaseIndex(parent.IndexID)
+	if err != nil {
+		return nil, actions, errors.New("error getting parent commit index, " + err.Error())
+	}
+	defer parentidx.Close()
+
+	newidx, err := a.AddReleaseIndex()
+	if err != nil {
+		return nil, actions, errors.New("error adding new index, " + err.Error())
+	}
+
+	for {
+		entry, err := parentidx.NextEntry()
+		if err != nil {
+			break
+		}
+
+		if entry.SourceItem.Name != name ||
+			DebVersionCompare(entry.SourceItem.Version, version) != 0 {
+			newidx.AddEntry(&entry)
+			continue
+		}
+
+		desc := entry.SourceItem.Name + " " + entry.SourceItem.Version.String()
+
+		if arch == "" {
+			actions = append(actions, ReleaseLogAction{
+				Type:        ActionDELETE,
+				Description: desc + " deleted by " + who,
+			})
+			continue
+		}
+
+		removed := false
+		if arch == "source" && len(entry.SourceItem.Files) > 0 {
+			entry.SourceItem.Files = []ReleaseIndexEntryItemFile{}
+			entry.SourceItem.ControlID = nil
+			removed = true
+		}
+
+		binItems := []ReleaseIndexEntryItem{}
+		for _, b := range entry.BinaryItems {
+			if b.Architecture == arch {
+				removed = true
+				continue
+			}
+			binItems = append(binItems, b)
+		}
+		entry.BinaryItems = binItems
+
+		if removed {
+			actions = append(actions, ReleaseLogAction{
+				Type:        ActionDELETE,
+				Description: desc + " (" + arch + ") deleted by " + who,
+			})
+		}
+
+		// Nothing left worth publishing
+		if len(entry.BinaryItems) == 0 && len(entry.SourceItem.Files) == 0 {
+			continue
+		}
+
+		newidx.AddEntry(&entry)
+	}
+
+	id, err := newidx.Close()
+	return StoreID(id), actions, err
+}
+
+// Apply the prune rules of the parent commit to its whole index and
+// return a new index
+func (a archiveStoreArchive) pruneRelease(parentid StoreID) (result StoreID, actions []ReleaseLogAction, err error) {
+	parent, err := a.GetRelease(parentid)
+	if err != nil {
+		return nil, []ReleaseLogAction{}, errors.New("error getting parent commit, " + err.Error())
+	}
+
+	return a.PruneIndex(parent.IndexID, parent.Config())
+}
+
+// PruneIndex applies the prune rules of the given configuration to a whole
+// index and returns a new index
+func (a archiveStoreArchive) PruneIndex(indexid StoreID, cfg *ReleaseConfig) (result StoreID, actions []ReleaseLogAction, err error) {
+	actions = make([]ReleaseLogAction, 0)
+
+	parentidx, err := a.OpenReleaseIndex(indexid)
+	if err != nil {
+		return nil, actions, errors.New("error getting parent commit index, " + err.Error())
+	}
+	defer parentidx.Close()
+
+	newidx, err := a.AddReleaseIndex()
+	if err != nil {
+		return nil, actions, errors.New("error adding new index, " + err.Error())
+	}
+
+	pruner := cfg.MakePruner()
+
+	for {
+		entry, err := parentidx.NextEntry()
+		if err != nil {
+			break
+		}
+
+		if pruner(&entry) {
+			actions = append(actions, ReleaseLogAction{
+				Type:        ActionPRUNE,
+				Description: entry.SourceItem.Name + " " + entry.SourceItem.Version.String(),
+			})
+			continue
+		}
+
+		newidx.AddEntry(&entry)
+	}
+
+	id, err := newidx.Close()
+	return StoreID(id), actions, err
+}
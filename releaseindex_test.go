@@ -0,0 +1,252 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func makeTestArchive(t *testing.T) (*archiveStoreArchive, func(), error) {
+	testBaseDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Errorf("Test setup failed, %v", err)
+		return nil, nil, err
+	}
+
+	clean := func() {
+		os.RemoveAll(testBaseDir)
+	}
+
+	storeDir := testBaseDir + "/store"
+	tmpDir := testBaseDir + "/tmp"
+	publicDir := testBaseDir + "/archive"
+	for _, d := range []string{storeDir, tmpDir, publicDir} {
+		os.Mkdir(d, 0777)
+	}
+
+	a := NewAptBlobArchive(&storeDir, &tmpDir, &publicDir, 0, true, ReleaseConfig{PoolPattern: "[a-z]"})
+
+	return a.(*archiveStoreArchive), clean, nil
+}
+
+// makeTestRelease creates a release in the archive with an index holding
+// the given entries
+func makeTestRelease(t *testing.T, a *archiveStoreArchive, name string, entries []*ReleaseIndexEntry) StoreID {
+	rootid, err := a.GetReleaseRoot(Release{CodeName: name, Suite: name, Version: "0"})
+	if err != nil {
+		t.Fatalf("creating release root failed, %v", err)
+	}
+
+	idx, err := a.AddReleaseIndex()
+	if err != nil {
+		t.Fatalf("creating index failed, %v", err)
+	}
+	for _, e := range entries {
+		idx.AddEntry(e)
+	}
+	idxid, err := idx.Close()
+	if err != nil {
+		t.Fatalf("creating index failed, %v", err)
+	}
+
+	rel, _ := a.GetRelease(rootid)
+	rel = rel.NewChild()
+	rel.IndexID = idxid
+	relid, err := a.AddRelease(rel)
+	if err != nil {
+		t.Fatalf("creating release failed, %v", err)
+	}
+
+	return relid
+}
+
+// makeTestControl stores a control file with the given fields
+func makeTestControl(t *testing.T, a *archiveStoreArchive, fields string) StoreID {
+	ctrl, err := ParseDebianControl(strings.NewReader(fields), nil)
+	if err != nil {
+		t.Fatalf("parsing control failed, %v", err)
+	}
+	id, err := a.AddControlFile(ctrl)
+	if err != nil {
+		t.Fatalf("storing control failed, %v", err)
+	}
+	return id
+}
+
+// makeTestBinaryItem creates an index item for a binary package, with a
+// stored control file holding any extra fields given
+func makeTestBinaryItem(t *testing.T, a *archiveStoreArchive, name string, version DebVersion, arch, comp, extra string) ReleaseIndexEntryItem {
+	return ReleaseIndexEntryItem{
+		Name:         name,
+		Version:      version,
+		Architecture: arch,
+		Component:    comp,
+		ControlID:    makeTestControl(t, a, "Package: "+name+"\nVersion: "+version.String()+"\nArchitecture: "+arch+"\n"+extra),
+		Files:        []ReleaseIndexEntryItemFile{{Name: name + "_" + version.String() + "_" + arch + ".deb"}},
+	}
+}
+
+func readTestIndex(t *testing.T, a *archiveStoreArchive, id StoreID) []*ReleaseIndexEntry {
+	var res []*ReleaseIndexEntry
+	idx, err := a.OpenReleaseIndex(id)
+	if err != nil {
+		t.Fatalf("opening index failed, %v", err)
+	}
+	defer idx.Close()
+
+	for {
+		e, err := idx.NextEntry()
+		if err != nil {
+			break
+		}
+		res = append(res, &e)
+	}
+	return res
+}
+
+var testRemoveInput = []*ReleaseIndexEntry{
+	&ReleaseIndexEntry{
+		SourceItem: ReleaseIndexEntryItem{Name: "pkga", Version: DebVersion{0, "2", "1"}},
+		BinaryItems: []ReleaseIndexEntryItem{
+			ReleaseIndexEntryItem{Name: "pkga", Version: DebVersion{0, "2", "1"}, Architecture: "amd64"},
+			ReleaseIndexEntryItem{Name: "pkga", Version: DebVersion{0, "2", "1"}, Architecture: "i386"},
+		},
+	},
+	&ReleaseIndexEntry{
+		SourceItem: ReleaseIndexEntryItem{Name: "pkga", Version: DebVersion{0, "1", "1"}},
+		BinaryItems: []ReleaseIndexEntryItem{
+			ReleaseIndexEntryItem{Name: "pkga", Version: DebVersion{0, "1", "1"}, Architecture: "amd64"},
+		},
+	},
+}
+
+var testRemoveEntry = []struct {
+	name    string
+	version string
+	arch    string
+	actions int
+	output  []*ReleaseIndexEntry
+}{
+	{"pkga", "2-1", "", 1, testRemoveInput[1:]},
+	{"pkga", "1-1", "amd64", 1, testRemoveInput[:1]},
+	{"pkga", "3-1", "", 0, testRemoveInput},
+	{"pkgb", "1-1", "", 0, testRemoveInput},
+	{"pkga", "2-1", "i386", 1, []*ReleaseIndexEntry{
+		&ReleaseIndexEntry{
+			SourceItem: ReleaseIndexEntryItem{Name: "pkga", Version: DebVersion{0, "2", "1"}},
+			BinaryItems: []ReleaseIndexEntryItem{
+				ReleaseIndexEntryItem{Name: "pkga", Version: DebVersion{0, "2", "1"}, Architecture: "amd64"},
+			},
+		},
+		testRemoveInput[1],
+	}},
+}
+
+func TestRemoveEntryFromRelease(t *testing.T) {
+	a, clean, err := makeTestArchive(t)
+	if err != nil {
+		return
+	}
+	defer clean()
+
+	relid := makeTestRelease(t, a, "test", testRemoveInput)
+
+	for i, tt := range testRemoveEntry {
+		idxid, actions, err := a.removeEntryFromRelease(relid, tt.name, MustParseDebVersion(tt.version), tt.arch, "test")
+		if err != nil {
+			t.Errorf("%d. failed: %v", i, err)
+			continue
+		}
+
+		if len(actions) != tt.actions {
+			t.Errorf("%d. wrong number of actions, expected %v, got %v", i, tt.actions, len(actions))
+		}
+
+		res := readTestIndex(t, a, idxid)
+		if !reflect.DeepEqual(res, tt.output) {
+			t.Errorf("%d. failed:\nExpected:\n%v\nGot:\n%v\n",
+				i,
+				formatTestItemList(tt.output),
+				formatTestItemList(res))
+		}
+	}
+}
+
+func TestFindEntry(t *testing.T) {
+	a, clean, err := makeTestArchive(t)
+	if err != nil {
+		return
+	}
+	defer clean()
+
+	relid := makeTestRelease(t, a, "test", testRemoveInput)
+	rel, _ := a.GetRelease(relid)
+
+	e, err := a.findEntry(rel.IndexID, "pkga", MustParseDebVersion("1-1"))
+	if err != nil {
+		t.Fatalf("findEntry failed, %v", err)
+	}
+	if !reflect.DeepEqual(e, testRemoveInput[1]) {
+		t.Errorf("findEntry returned the wrong entry, %v", formatTestItemList([]*ReleaseIndexEntry{e}))
+	}
+
+	_, err = a.findEntry(rel.IndexID, "pkga", MustParseDebVersion("3-1"))
+	if err != ErrPackageNotFound {
+		t.Errorf("findEntry should not find missing version, got %v", err)
+	}
+}
+
+func TestPruneRelease(t *testing.T) {
+	a, clean, err := makeTestArchive(t)
+	if err != nil {
+		return
+	}
+	defer clean()
+
+	relid := makeTestRelease(t, a, "test", testRemoveInput)
+
+	// The default rules in the test archive do not prune anything
+	_, actions, err := a.pruneRelease(relid)
+	if err != nil {
+		t.Fatalf("prune failed, %v", err)
+	}
+	if len(actions) != 0 {
+		t.Errorf("nothing should have been pruned, got %v", actions)
+	}
+
+	rel, _ := a.GetRelease(relid)
+	n := rel.NewChild()
+	cfg := *n.Config()
+	cfg.PruneRules = ".*_0-0"
+
+	// Rules that have not been committed yet can be applied
+	_, actions, err = a.PruneIndex(rel.IndexID, &cfg)
+	if err != nil {
+		t.Fatalf("prune failed, %v", err)
+	}
+	if len(actions) != 1 {
+		t.Errorf("expected one prune action, got %v", actions)
+	}
+
+	n.ConfigID, _ = a.AddReleaseConfig(cfg)
+	n.config = nil
+	relid, _ = a.AddRelease(n)
+
+	idxid, actions, err := a.pruneRelease(relid)
+	if err != nil {
+		t.Fatalf("prune failed, %v", err)
+	}
+	if len(actions) != 1 || actions[0].Type != ActionPRUNE {
+		t.Errorf("expected one prune action, got %v", actions)
+	}
+
+	expected := testRemoveInput[:1]
+	res := readTestIndex(t, a, idxid)
+	if !reflect.DeepEqual(res, expected) {
+		t.Errorf("prune failed:\nExpected:\n%v\nGot:\n%v\n",
+			formatTestItemList(expected),
+			formatTestItemList(res))
+	}
+}
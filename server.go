@@ -22,10 +22,11 @@ var cfg struct {
 }
 
 var state struct {
-	Archive        Archiver              // The generator for updating the repo
-	SessionManager *UploadSessionManager // The session manager
-	Lock           *Governor             // Locks to ensure the repo update is atomic
-	getCount       *expvar.Int           // Download count
+	Archive         Archiver              // The generator for updating the repo
+	SessionManager  *UploadSessionManager // The session manager
+	Lock            *Governor             // Locks to ensure the repo update is atomic
+	getCount        *expvar.Int           // Download count
+	RefreshInterval time.Duration         // How often expiring release files are refreshed
 }
 
 // appError is a custom error type to
@@ -105,3 +106,36 @@ func AuthorisedAdmin(ctx context.Context, w http.ResponseWriter, r *http.Request
 	}
 	return true
 }
+
+// updateResult is returned to clients after a request that has
+// regenerated a distribution
+type updateResult struct {
+	Release           *Release
+	PreGenHookOutput  *HookOutput `json:",omitempty"`
+	PostGenHookOutput *HookOutput `json:",omitempty"`
+}
+
+// updateWithGenHooks runs the pre and post generation hooks around a
+// function that updates the named distribution. This should only be
+// called with the write lock held
+func updateWithGenHooks(name string, update func() error) (*updateResult, error) {
+	var res updateResult
+
+	preHookResult := cfg.PreGenHook.Run(name)
+	res.PreGenHookOutput = &preHookResult
+
+	if err := update(); err != nil {
+		return &res, err
+	}
+
+	postHookResult := cfg.PostGenHook.Run(name)
+	res.PostGenHookOutput = &postHookResult
+
+	rel, err := state.Archive.GetDist(name)
+	if err != nil {
+		return &res, err
+	}
+	res.Release = rel
+
+	return &res, nil
+}
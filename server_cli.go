@@ -31,9 +31,20 @@ func CmdServe(c *cli.Context) {
 	verifyChangesSufficient := c.Bool("default-verify-changes-sufficient")
 	acceptLoneDebs := c.Bool("default-accept-lone-debs")
 	verifyDebs := c.Bool("default-verify-debs")
+	verifyDscs := c.Bool("default-verify-dscs")
 	pruneRulesStr := c.String("default-prune")
 	autoTrim := c.Bool("default-auto-trim")
 	trimLen := c.Int("default-auto-trim-length")
+	trimAge := c.String("default-auto-trim-age")
+	indexCompressionsStr := c.String("default-index-compressions")
+	byHashGenerations := c.Int("default-by-hash-generations")
+	stripLongDescriptions := c.Bool("default-strip-long-descriptions")
+	componentsStr := c.String("default-components")
+	architecturesStr := c.String("default-architectures")
+	separateArchAll := c.Bool("default-separate-arch-all")
+	refreshInterval := c.Duration("refresh-interval")
+	publishGrace := c.Duration("publish-grace")
+	serveFromStore := c.Bool("serve-from-store")
 
 	setupLog(logFile)
 
@@ -53,6 +64,35 @@ func CmdServe(c *cli.Context) {
 		log.Fatalln(err)
 	}
 
+	if trimLen < 0 {
+		log.Fatalln("--default-auto-trim-length must not be negative")
+	}
+
+	if trimAge != "" {
+		if _, err := ParseAge(trimAge); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	indexCompressions, err := ParseIndexCompressions(indexCompressionsStr)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	components, err := ParseComponents(componentsStr)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	architectures, err := ParseArchitectures(architecturesStr)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if byHashGenerations < 0 {
+		log.Fatalln("--default-by-hash-generations must not be negative")
+	}
+
 	if _, err := regexp.CompilePOSIX("^(" + poolPattern + ")"); err != nil {
 		log.Fatalln(err)
 	}
@@ -61,14 +101,24 @@ func CmdServe(c *cli.Context) {
 		&storeDir,
 		&tmpDir,
 		&publicDir,
+		publishGrace,
+		!serveFromStore,
 		ReleaseConfig{
 			VerifyChanges:           verifyChanges,
 			VerifyChangesSufficient: verifyChangesSufficient,
 			VerifyDebs:              verifyDebs,
+			VerifyDscs:              verifyDscs,
 			AcceptLoneDebs:          acceptLoneDebs,
 			PruneRules:              pruneRulesStr,
 			AutoTrim:                autoTrim,
 			AutoTrimLength:          trimLen,
+			AutoTrimAge:             trimAge,
+			IndexCompressions:       indexCompressions,
+			ByHashGenerations:       byHashGenerations,
+			StripLongDescriptions:   stripLongDescriptions,
+			Components:              components,
+			Architectures:           architectures,
+			SeparateArchAll:         separateArchAll,
 			PoolPattern:             poolPattern,
 		},
 	)
@@ -87,6 +137,11 @@ func CmdServe(c *cli.Context) {
 		NewScriptHook(&uploadHook),
 	)
 
+	state.RefreshInterval = refreshInterval
+	if refreshInterval > 0 {
+		go refreshReleases(refreshInterval)
+	}
+
 	r := mux.NewRouter()
 
 	r.HandleFunc("/debug/pprof/", pprof.Index)
@@ -95,7 +150,7 @@ func CmdServe(c *cli.Context) {
 	r.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
 	r.Handle("/debug/vars", http.DefaultServeMux)
 
-	r.PathPrefix("/repo/").Handler(appHandler(makeHTTPDownloadHandler()))
+	r.PathPrefix("/repo/").Handler(appHandler(makeHTTPDownloadHandler(serveFromStore)))
 
 	r.Handle("/dists", appHandler(httpDistsHandler))
 	r.Handle("/dists/{name}", appHandler(httpDistsHandler))
@@ -104,6 +159,15 @@ func CmdServe(c *cli.Context) {
 	r.Handle("/dists/{name}/config/publickeys", appHandler(httpConfigPublicKeysHandler))
 	r.Handle("/dists/{name}/config/publickeys/{id}", appHandler(httpConfigPublicKeysHandler))
 	r.Handle("/dists/{name}/log", appHandler(httpLogHandler))
+	r.Handle("/dists/{name}/diff", appHandler(httpDiffHandler))
+	r.Handle("/dists/{name}/rollback", appHandler(httpDistsRollbackHandler))
+	r.Handle("/dists/{name}/repair", appHandler(httpDistsRepairHandler))
+	r.Handle("/dists/{name}/snapshots", appHandler(httpSnapshotsHandler))
+	r.Handle("/dists/{name}/snapshots/{tag}", appHandler(httpSnapshotsHandler))
+	r.Handle("/dists/{name}/prune", appHandler(httpPruneHandler))
+	r.Handle("/dists/{name}/prune/preview", appHandler(httpPrunePreviewHandler))
+	r.Handle("/dists/{name}/packages", appHandler(httpPackagesHandler))
+	r.Handle("/dists/{name}/packages/{source}/{version}", appHandler(httpPackagesHandler))
 	r.Handle("/dists/{name}/upload", appHandler(httpUploadHandler))
 	r.Handle("/dists/{name}/upload/{session}", appHandler(httpUploadHandler))
 
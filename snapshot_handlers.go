@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+)
+
+// This build a function to manage the snapshots of a distribution
+func httpSnapshotsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	switch r.Method {
+	case "GET":
+		return handleWithReadLock(doHTTPSnapshotsGetHandler, ctx, w, r)
+	case "PUT":
+		return handleWithWriteLock(doHTTPSnapshotsPutHandler, ctx, w, r)
+	case "DELETE":
+		return handleWithWriteLock(doHTTPSnapshotsDeleteHandler, ctx, w, r)
+	default:
+		return sendResponse(w, http.StatusMethodNotAllowed, nil)
+	}
+}
+
+func doHTTPSnapshotsGetHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	vars := mux.Vars(r)
+	name := vars["name"]
+	tag, tagGiven := vars["tag"]
+
+	_, err := state.Archive.GetDist(name)
+	switch {
+	case err == nil:
+	case os.IsNotExist(err):
+		return sendResponse(w, http.StatusNotFound, nil)
+	default:
+		return &appError{Error: err}
+	}
+
+	if !tagGiven {
+		snaps := make(map[string]StoreID, 0)
+		for tag, id := range state.Archive.Snapshots() {
+			rel, err := state.Archive.GetRelease(id)
+			if err != nil {
+				return &appError{Error: fmt.Errorf("failed to retrieve snapshot %v, %v", tag, err)}
+			}
+			if rel.CodeName == name {
+				snaps[tag] = id
+			}
+		}
+		return sendOKResponse(w, snaps)
+	}
+
+	rel, err := state.Archive.GetSnapshot(tag)
+	switch {
+	case err == nil && rel.CodeName == name:
+		return sendOKResponse(w, rel)
+	case err == nil, os.IsNotExist(err):
+		return sendResponse(w, http.StatusNotFound, nil)
+	default:
+		return &appError{Error: err}
+	}
+}
+
+// Pin a release of a distribution, by default the current one, under a
+// snapshot name
+func doHTTPSnapshotsPutHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	if !AuthorisedAdmin(ctx, w, r) {
+		return sendResponse(w, http.StatusUnauthorized, nil)
+	}
+	vars := mux.Vars(r)
+	name := vars["name"]
+	tag := vars["tag"]
+
+	id, ok := state.Archive.Dists()[name]
+	if !ok {
+		return sendResponse(w, http.StatusNotFound, nil)
+	}
+
+	if idStr := r.URL.Query().Get("id"); idStr != "" {
+		var err error
+		id, err = StoreIDFromString(idStr)
+		if err != nil || len(id) == 0 {
+			return sendResponse(w, http.StatusBadRequest, "a valid release id must be given")
+		}
+	}
+
+	rel, err := state.Archive.GetRelease(id)
+	if err != nil {
+		return sendResponse(w, http.StatusNotFound, "release not found")
+	}
+	if rel.CodeName != name {
+		return sendResponse(w, http.StatusBadRequest, "release is not from this distribution")
+	}
+
+	err = state.Archive.SetSnapshot(tag, id)
+	switch {
+	case err == nil:
+	case err == ErrSnapshotExists:
+		return sendResponse(w, http.StatusConflict, "snapshots cannot be updated")
+	default:
+		return &appError{Error: fmt.Errorf("failed to create snapshot, %v", err)}
+	}
+
+	return doHTTPSnapshotsGetHandler(ctx, w, r)
+}
+
+func doHTTPSnapshotsDeleteHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	if !AuthorisedAdmin(ctx, w, r) {
+		return sendResponse(w, http.StatusUnauthorized, nil)
+	}
+	vars := mux.Vars(r)
+	name := vars["name"]
+	tag, tagGiven := vars["tag"]
+
+	if !tagGiven {
+		return sendResponse(w, http.StatusBadRequest, nil)
+	}
+
+	rel, err := state.Archive.GetSnapshot(tag)
+	switch {
+	case err == nil && rel.CodeName == name:
+	case err == nil, os.IsNotExist(err):
+		return sendResponse(w, http.StatusNotFound, nil)
+	default:
+		return &appError{Error: err}
+	}
+
+	err = state.Archive.DeleteSnapshot(tag)
+	if err != nil {
+		return &appError{Error: fmt.Errorf("failed to delete snapshot, %v", err)}
+	}
+
+	return sendOKResponse(w, "DELETED")
+}
@@ -0,0 +1,527 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"container/list"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// storeTreeCacheSize is the number of releases whose published files are
+// kept in memory by the store file system
+const storeTreeCacheSize = 32
+
+// storeGunzipCacheSize is the total size of the decompressed indexes that
+// are kept in memory by the store file system
+const storeGunzipCacheSize = 64 << 20
+
+// storeFile describes a published file that is held in the store
+type storeFile struct {
+	id      StoreID
+	gunzip  bool   // The file is the decompressed content of id
+	size    int64  // The size of the file, if known
+	sha256  string // The SHA256 of the file, if known
+	modTime time.Time
+}
+
+// etag returns the entity tag for the file, derived from its content hash
+func (f *storeFile) etag() string {
+	switch {
+	case !f.gunzip:
+		return `"` + f.id.String() + `"`
+	case f.sha256 != "":
+		return `"` + f.sha256 + `"`
+	default:
+		return `"` + f.id.String() + `-gunzip"`
+	}
+}
+
+// storeTree maps the paths of the files published for a release,
+// relative to the archive directory, to the files in the store
+type storeTree map[string]storeFile
+
+// children lists the entries of a directory in the tree, directories are
+// listed with a nil file
+func (t storeTree) children(dir string) map[string]*storeFile {
+	res := make(map[string]*storeFile)
+	prefix := dir + "/"
+	for p := range t {
+		if !strings.HasPrefix(p, prefix) {
+			continue
+		}
+
+		name := strings.TrimPrefix(p, prefix)
+		if i := strings.Index(name, "/"); i != -1 {
+			res[name[:i]] = nil
+			continue
+		}
+		f := t[p]
+		res[name] = &f
+	}
+	return res
+}
+
+// storeFileSystem is an http.FileSystem that serves the published
+// distributions and snapshots directly from the store, laid out as they
+// would be in the archive directory
+type storeFileSystem struct {
+	archive *archiveStoreArchive
+	server  http.Handler
+
+	sync.Mutex
+	trees         map[string]storeTree
+	gunzipped     map[string]*gunzippedIndex // Decompressed indexes, by store id
+	gunzipOrder   *list.List                 // Decompressed indexes, most recently used first
+	gunzippedSize int64
+}
+
+// gunzippedIndex holds the decompressed content of an index. done is
+// closed once the index has been decompressed
+type gunzippedIndex struct {
+	key  string
+	data []byte
+	err  error
+	done chan struct{}
+	elem *list.Element
+}
+
+func newStoreFileSystem(a *archiveStoreArchive) *storeFileSystem {
+	fs := &storeFileSystem{
+		archive:     a,
+		trees:       make(map[string]storeTree),
+		gunzipped:   make(map[string]*gunzippedIndex),
+		gunzipOrder: list.New(),
+	}
+	fs.server = http.FileServer(fs)
+	return fs
+}
+
+// ServeHTTP serves the published files, setting the ETag of each file
+// from its content hash so that clients can make conditional requests
+func (fs *storeFileSystem) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if f, _, err := fs.lookup(r.URL.Path); err == nil && f != nil {
+		w.Header().Set("ETag", f.etag())
+	}
+	fs.server.ServeHTTP(w, r)
+}
+
+// Open opens the published file, or directory, at name
+func (fs *storeFileSystem) Open(name string) (http.File, error) {
+	f, children, err := fs.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if f != nil {
+		return fs.openFile(path.Base(name), f)
+	}
+
+	dir := &storeHTTPDir{
+		info: storeFileInfo{name: path.Base(name), dir: true},
+	}
+	for childName, child := range children {
+		info := storeFileInfo{name: childName, dir: child == nil}
+		if child != nil {
+			info.modTime = child.modTime
+			info.size = child.size
+			if !child.gunzip {
+				info.size, _ = fs.archive.Size(child.id)
+			}
+		}
+		dir.entries = append(dir.entries, info)
+	}
+	sort.Sort(byFileInfoName(dir.entries))
+
+	return dir, nil
+}
+
+// lookup finds the file, or the entries of the directory, at name
+func (fs *storeFileSystem) lookup(name string) (*storeFile, map[string]*storeFile, error) {
+	p := strings.Trim(path.Clean("/"+name), "/")
+	var parts []string
+	if p != "" {
+		parts = strings.Split(p, "/")
+	}
+
+	dirOf := func(names ...string) map[string]*storeFile {
+		res := make(map[string]*storeFile)
+		for _, n := range names {
+			res[n] = nil
+		}
+		return res
+	}
+
+	// Releases available under dists and pool, by codename
+	releases := fs.archive.Dists()
+	if len(parts) > 0 && parts[0] == "snapshots" {
+		snaps := fs.archive.Snapshots()
+		if len(parts) == 1 {
+			var tags []string
+			for tag := range snaps {
+				tags = append(tags, tag)
+			}
+			return nil, dirOf(tags...), nil
+		}
+
+		id, ok := snaps[parts[1]]
+		if !ok {
+			return nil, nil, os.ErrNotExist
+		}
+		rel, err := fs.archive.GetRelease(id)
+		if err != nil {
+			return nil, nil, err
+		}
+		releases = map[string]StoreID{rel.CodeName: id}
+
+		parts = parts[2:]
+		if len(parts) == 0 {
+			return nil, dirOf("dists", "pool"), nil
+		}
+	} else if len(parts) == 0 {
+		return nil, dirOf("dists", "pool", "snapshots"), nil
+	}
+
+	if parts[0] != "dists" && parts[0] != "pool" {
+		return nil, nil, os.ErrNotExist
+	}
+
+	if len(parts) == 1 {
+		var names []string
+		for n := range releases {
+			names = append(names, n)
+		}
+		return nil, dirOf(names...), nil
+	}
+
+	id, ok := releases[parts[1]]
+	if !ok {
+		return nil, nil, os.ErrNotExist
+	}
+	rel, err := fs.archive.GetRelease(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	tree, err := fs.releaseTree(rel)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p = strings.Join(parts, "/")
+	if f, ok := tree[p]; ok {
+		return &f, nil, nil
+	}
+
+	children := tree.children(p)
+	if len(children) == 0 {
+		return nil, nil, os.ErrNotExist
+	}
+
+	return nil, children, nil
+}
+
+// releaseTree returns the files published for a release
+func (fs *storeFileSystem) releaseTree(rel *Release) (storeTree, error) {
+	key := rel.id.String()
+
+	fs.Lock()
+	tree, ok := fs.trees[key]
+	fs.Unlock()
+	if ok {
+		return tree, nil
+	}
+
+	tree = make(storeTree)
+	distBase := "dists/" + rel.CodeName + "/"
+
+	releaseFiles := map[string]StoreID{
+		"Release":     rel.Release,
+		"InRelease":   rel.InRelease,
+		"Release.gpg": rel.ReleaseGPG,
+	}
+	for name, id := range releaseFiles {
+		if len(id) != 0 {
+			tree[distBase+name] = storeFile{id: id, modTime: rel.Date}
+		}
+	}
+
+	for p, f := range releaseIndexFiles(rel) {
+		tree[distBase+p] = f
+	}
+
+	// The indexes of previous releases remain available by hash
+	curr := rel
+	for i := 0; ; i++ {
+		for p, f := range releaseIndexFiles(curr) {
+			if f.sha256 != "" {
+				tree[distBase+path.Dir(p)+"/by-hash/SHA256/"+f.sha256] = f
+			}
+		}
+
+		if i >= rel.Config().ByHashGenerations || curr.ParentID.String() == fs.archive.EmptyFileID().String() {
+			break
+		}
+
+		parent, err := fs.archive.GetRelease(curr.ParentID)
+		if err != nil {
+			break
+		}
+		curr = parent
+	}
+
+	files, err := fs.archive.poolFiles(rel)
+	if err != nil {
+		return nil, err
+	}
+	for p, id := range files {
+		tree[p] = storeFile{id: id}
+	}
+
+	fs.Lock()
+	if len(fs.trees) >= storeTreeCacheSize {
+		fs.trees = make(map[string]storeTree)
+	}
+	fs.trees[key] = tree
+	fs.Unlock()
+
+	return tree, nil
+}
+
+// gunzip returns the decompressed content of a store item
+func (a *archiveStoreArchive) gunzip(id StoreID) ([]byte, error) {
+	rdr, err := a.Open(id)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+
+	gunzipper, err := gzip.NewReader(rdr)
+	if err != nil {
+		return nil, err
+	}
+	defer gunzipper.Close()
+
+	return ioutil.ReadAll(gunzipper)
+}
+
+// releaseIndexFiles lists the index files of a release, by their path
+// relative to the dist directory
+func releaseIndexFiles(rel *Release) map[string]storeFile {
+	files := make(map[string]storeFile)
+
+	for _, comp := range rel.Components {
+		// The details of the files, where the release recorded them
+		known := make(map[string]IndexFile)
+		indexFiles := append([]IndexFile{}, comp.SourcesIndexFiles...)
+		indexFiles = append(indexFiles, comp.TranslationsIndexFiles...)
+		for _, arch := range comp.Architectures {
+			indexFiles = append(indexFiles, arch.IndexFiles...)
+		}
+		for _, f := range indexFiles {
+			known[f.Path] = f
+		}
+
+		add := func(p string, id StoreID, gunzip bool) {
+			if len(id) == 0 {
+				return
+			}
+			f := storeFile{id: id, gunzip: gunzip, modTime: rel.Date}
+			if info, ok := known[p]; ok {
+				f.size = info.Size
+				f.sha256 = info.SHA256
+			}
+			files[comp.Name+"/"+p] = f
+		}
+
+		addIndex := func(p string, gz StoreID, compressed []CompressedIndex) {
+			add(p, gz, true)
+			add(p+".gz", gz, false)
+			for _, c := range compressed {
+				add(p+"."+c.Compression, c.ID, false)
+			}
+		}
+
+		addIndex("source/Sources", comp.SourcesGz, comp.SourcesCompressed)
+		add("source/Release", comp.SourcesRelease, false)
+
+		add("i18n/Translation-en.gz", comp.TranslationsGz, false)
+		for _, c := range comp.TranslationsCompressed {
+			add("i18n/Translation-en."+c.Compression, c.ID, false)
+		}
+
+		for _, arch := range comp.Architectures {
+			addIndex("binary-"+arch.Name+"/Packages", arch.PackagesGz, arch.PackagesCompressed)
+			add("binary-"+arch.Name+"/Release", arch.ReleaseFile, false)
+			add("Contents-"+arch.Name+".gz", arch.ContentsGz, false)
+		}
+	}
+
+	return files
+}
+
+// gunzippedData returns the decompressed content of a store item. The most
+// recently used indexes are cached, and concurrent requests for the same
+// index share a single decompression
+func (fs *storeFileSystem) gunzippedData(id StoreID) ([]byte, error) {
+	key := id.String()
+
+	fs.Lock()
+	if g, ok := fs.gunzipped[key]; ok {
+		if g.elem != nil {
+			fs.gunzipOrder.MoveToFront(g.elem)
+		}
+		fs.Unlock()
+		<-g.done
+		return g.data, g.err
+	}
+	g := &gunzippedIndex{key: key, done: make(chan struct{})}
+	fs.gunzipped[key] = g
+	fs.Unlock()
+
+	g.data, g.err = fs.archive.gunzip(id)
+
+	fs.Lock()
+	defer fs.Unlock()
+	close(g.done)
+
+	if g.err != nil || int64(len(g.data)) > storeGunzipCacheSize {
+		delete(fs.gunzipped, key)
+		return g.data, g.err
+	}
+
+	g.elem = fs.gunzipOrder.PushFront(g)
+	fs.gunzippedSize += int64(len(g.data))
+	for fs.gunzippedSize > storeGunzipCacheSize {
+		old := fs.gunzipOrder.Remove(fs.gunzipOrder.Back()).(*gunzippedIndex)
+		delete(fs.gunzipped, old.key)
+		fs.gunzippedSize -= int64(len(old.data))
+	}
+
+	return g.data, nil
+}
+
+// openFile opens a file from the store. Uncompressed indexes are not kept
+// in the store, so are decompressed into memory
+func (fs *storeFileSystem) openFile(name string, f *storeFile) (http.File, error) {
+	info := storeFileInfo{name: name, modTime: f.modTime}
+
+	if f.gunzip {
+		data, err := fs.gunzippedData(f.id)
+		if err != nil {
+			return nil, err
+		}
+		info.size = int64(len(data))
+
+		return &storeHTTPFile{ReadSeeker: bytes.NewReader(data), Closer: ioutil.NopCloser(nil), info: info}, nil
+	}
+
+	rdr, err := fs.archive.Open(f.id)
+	if err != nil {
+		return nil, err
+	}
+
+	seeker, ok := rdr.(io.ReadSeeker)
+	if !ok {
+		rdr.Close()
+		return nil, errors.New("store item " + f.id.String() + " is not seekable")
+	}
+
+	info.size, err = fs.archive.Size(f.id)
+	if err != nil {
+		rdr.Close()
+		return nil, err
+	}
+
+	return &storeHTTPFile{ReadSeeker: seeker, Closer: rdr, info: info}, nil
+}
+
+// storeHTTPFile is a file from the store being served over http
+type storeHTTPFile struct {
+	io.ReadSeeker
+	io.Closer
+	info storeFileInfo
+}
+
+func (f *storeHTTPFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, errors.New(f.info.name + " is not a directory")
+}
+
+func (f *storeHTTPFile) Stat() (os.FileInfo, error) {
+	return f.info, nil
+}
+
+// storeHTTPDir is a directory of published files being served over http
+type storeHTTPDir struct {
+	info    storeFileInfo
+	entries []os.FileInfo
+}
+
+func (d *storeHTTPDir) Close() error {
+	return nil
+}
+
+func (d *storeHTTPDir) Read(p []byte) (int, error) {
+	return 0, errors.New(d.info.name + " is a directory")
+}
+
+func (d *storeHTTPDir) Seek(offset int64, whence int) (int64, error) {
+	return 0, errors.New(d.info.name + " is a directory")
+}
+
+func (d *storeHTTPDir) Readdir(count int) ([]os.FileInfo, error) {
+	if count <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	if count > len(d.entries) {
+		count = len(d.entries)
+	}
+	entries := d.entries[:count]
+	d.entries = d.entries[count:]
+	return entries, nil
+}
+
+func (d *storeHTTPDir) Stat() (os.FileInfo, error) {
+	return d.info, nil
+}
+
+// storeFileInfo describes a published file, or directory
+type storeFileInfo struct {
+	name    string
+	size    int64
+	dir     bool
+	modTime time.Time
+}
+
+func (i storeFileInfo) Name() string       { return i.name }
+func (i storeFileInfo) Size() int64        { return i.size }
+func (i storeFileInfo) ModTime() time.Time { return i.modTime }
+func (i storeFileInfo) IsDir() bool        { return i.dir }
+func (i storeFileInfo) Sys() interface{}   { return nil }
+
+func (i storeFileInfo) Mode() os.FileMode {
+	if i.dir {
+		return os.ModeDir | 0555
+	}
+	return 0444
+}
+
+type byFileInfoName []os.FileInfo
+
+func (a byFileInfoName) Len() int           { return len(a) }
+func (a byFileInfoName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byFileInfoName) Less(i, j int) bool { return a[i].Name() < a[j].Name() }
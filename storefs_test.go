@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStoreFileSystem(t *testing.T) {
+	a, clean, err := makeTestArchive(t)
+	if err != nil {
+		return
+	}
+	defer clean()
+	a.reify = false
+
+	debID, err := a.CopyToStore(ioutil.NopCloser(strings.NewReader("not really a deb")))
+	if err != nil {
+		t.Fatalf("storing deb failed, %v", err)
+	}
+
+	ctrl, _ := ParseDebianControl(strings.NewReader("Package: pkga\nVersion: 1-1\nArchitecture: amd64\nDescription: a package\n"), nil)
+	ctrlID, err := a.AddControlFile(ctrl)
+	if err != nil {
+		t.Fatalf("storing control failed, %v", err)
+	}
+
+	relid := makeTestRelease(t, a, "test", []*ReleaseIndexEntry{
+		{
+			SourceItem: ReleaseIndexEntryItem{Name: "pkga", Version: DebVersion{0, "1", "1"}, Architecture: "source", Component: "main"},
+			BinaryItems: []ReleaseIndexEntryItem{{
+				Name:         "pkga",
+				Version:      DebVersion{0, "1", "1"},
+				Architecture: "amd64",
+				Component:    "main",
+				ControlID:    ctrlID,
+				Files:        []ReleaseIndexEntryItemFile{{Name: "pkga_1-1_amd64.deb", StoreID: debID}},
+			}},
+		},
+	})
+	rel, _ := a.GetRelease(relid)
+	relid, err = NewRelease(a, relid, rel.IndexID, nil)
+	if err != nil {
+		t.Fatalf("creating release failed, %v", err)
+	}
+	a.SetDist("test", relid)
+	rel, _ = a.GetRelease(relid)
+
+	if err = a.ReifyRelease(relid); err != nil {
+		t.Fatalf("reify failed, %v", err)
+	}
+	if _, err := ioutil.ReadDir(a.PublicDir() + "/dists"); err == nil {
+		t.Errorf("release should not have been published to the archive directory")
+	}
+
+	get := func(path string, headers map[string]string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		a.StoreHandler().ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/dists/test/Release", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("fetching Release failed, %v", w.Code)
+	}
+	if etag := w.Header().Get("ETag"); etag != `"`+rel.Release.String()+`"` {
+		t.Errorf("expected ETag of Release to be its StoreID, got %v", etag)
+	}
+
+	w = get("/dists/test/Release", map[string]string{"If-None-Match": w.Header().Get("ETag")})
+	if w.Code != http.StatusNotModified {
+		t.Errorf("expected Release to be unmodified, got %v", w.Code)
+	}
+
+	w = get("/dists/test/main/binary-amd64/Packages", nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Package: pkga\n") {
+		t.Fatalf("fetching Packages failed, %v %q", w.Code, w.Body.String())
+	}
+	pkgs := w.Body.String()
+
+	sum := sha256.Sum256([]byte(pkgs))
+	w = get("/dists/test/main/binary-amd64/by-hash/SHA256/"+hex.EncodeToString(sum[:]), nil)
+	if w.Code != http.StatusOK || w.Body.String() != pkgs {
+		t.Errorf("fetching Packages by hash failed, %v", w.Code)
+	}
+
+	if n := len(a.storeFS.gunzipped); n != 1 || a.storeFS.gunzippedSize != int64(len(pkgs)) {
+		t.Errorf("expected decompressed Packages to be cached once, got %v entries of %v bytes", n, a.storeFS.gunzippedSize)
+	}
+
+	var pkgsKey string
+	for k := range a.storeFS.gunzipped {
+		pkgsKey = k
+	}
+
+	a.storeFS.gunzippedSize += storeGunzipCacheSize
+	w = get("/dists/test/main/source/Sources", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("fetching Sources failed, %v", w.Code)
+	}
+	if _, ok := a.storeFS.gunzipped[pkgsKey]; ok {
+		t.Errorf("expected decompressed Packages to be evicted from the cache")
+	}
+
+	w = get("/pool/test/p/pkga/1-1/pkga_1-1_amd64.deb", map[string]string{"Range": "bytes=4-9"})
+	if w.Code != http.StatusPartialContent || w.Body.String() != "really" {
+		t.Errorf("fetching range of pool file failed, %v %q", w.Code, w.Body.String())
+	}
+
+	w = get("/dists/test/main/", nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "binary-amd64/") {
+		t.Errorf("listing component failed, %v %q", w.Code, w.Body.String())
+	}
+
+	for _, path := range []string{"/dists/test/main/binary-i386/Packages", "/dists/other/Release", "/pool/test/p/pkga/1-1/missing.deb"} {
+		if w = get(path, nil); w.Code != http.StatusNotFound {
+			t.Errorf("expected %v to be missing, got %v", path, w.Code)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+)
+
+// descriptionMD5 calculates the Description-md5 of a package description,
+// this is the md5 of the full description as it appears in the control
+// file, including the trailing newline
+func descriptionMD5(desc []*string) string {
+	hasher := md5.New()
+	for i, l := range desc {
+		if i != 0 {
+			io.WriteString(hasher, " ")
+		}
+		io.WriteString(hasher, *l+"\n")
+	}
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// translateDescription adds a Description-md5 to a binary package control
+// paragraph, and returns the matching Translation-en paragraph. If strip is
+// set, the long description is removed from the package paragraph. ok is
+// false if the package has no description
+func translateDescription(para ControlParagraph, strip bool) (trans ControlParagraph, ok bool) {
+	desc, ok := para.GetValues("Description")
+	if !ok || len(desc) == 0 {
+		return nil, false
+	}
+
+	sum := descriptionMD5(desc)
+	para.SetValue("Description-md5", sum)
+
+	trans = MakeControlParagraph()
+	trans["Package"] = para["Package"]
+	trans.SetValue("Description-md5", sum)
+	trans["Description-en"] = desc
+
+	if strip {
+		para["Description"] = desc[:1]
+	}
+
+	return trans, true
+}
+
+// translationTempData tracks the Translation-en index of a component
+// while it is being generated
+type translationTempData struct {
+	gz         *compressedTempData
+	compressed []*compressedTempData
+	writer     io.Writer
+	seen       map[string]bool
+}
+
+// add writes a translation paragraph to the index, if an identical
+// description for the package has not already been written
+func (t *translationTempData) add(trans ControlParagraph) {
+	name, _ := trans.GetValue("Package")
+	sum, _ := trans.GetValue("Description-md5")
+	if t.seen[name+" "+sum] {
+		return
+	}
+	t.seen[name+" "+sum] = true
+
+	WriteDebianControl(t.writer, ControlFile{Data: []*ControlParagraph{&trans}}, []string{"Package", "Description-md5", "Description-en"}, nil)
+	t.writer.Write([]byte("\n"))
+}
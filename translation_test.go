@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+const testTranslationControl = `Package: test
+Version: 1.0-1
+Architecture: amd64
+Description: a test package
+ This is the long description
+ .
+ of a test package
+`
+
+func TestTranslateDescription(t *testing.T) {
+	sum := md5.Sum([]byte("a test package\n This is the long description\n .\n of a test package\n"))
+	expectedMD5 := hex.EncodeToString(sum[:])
+
+	for _, strip := range []bool{false, true} {
+		ctrl, err := ParseDebianControl(strings.NewReader(testTranslationControl), nil)
+		if err != nil {
+			t.Fatalf("parsing control failed, %v", err)
+		}
+		para := *ctrl.Data[0]
+
+		trans, ok := translateDescription(para, strip)
+		if !ok {
+			t.Fatalf("no translation returned")
+		}
+
+		if v, _ := para.GetValue("Description-md5"); v != expectedMD5 {
+			t.Errorf("expected Description-md5 %v, got %v", expectedMD5, v)
+		}
+		if v, _ := trans.GetValue("Description-md5"); v != expectedMD5 {
+			t.Errorf("expected translation Description-md5 %v, got %v", expectedMD5, v)
+		}
+		if v, _ := trans.GetValues("Description-en"); len(v) != 4 {
+			t.Errorf("expected long description in translation, got %v lines", len(v))
+		}
+
+		desc, _ := para.GetValues("Description")
+		switch {
+		case strip && len(desc) != 1:
+			t.Errorf("expected long description to be stripped, got %v lines", len(desc))
+		case !strip && len(desc) != 4:
+			t.Errorf("expected long description to be kept, got %v lines", len(desc))
+		}
+	}
+
+	ctrl, _ := ParseDebianControl(strings.NewReader("Package: test\nVersion: 1.0\n"), nil)
+	if _, ok := translateDescription(*ctrl.Data[0], false); ok {
+		t.Errorf("translation returned for package with no description")
+	}
+}
+
+func TestTranslationTempData(t *testing.T) {
+	buf := &bytes.Buffer{}
+	trans := &translationTempData{writer: buf, seen: make(map[string]bool)}
+
+	for i := 0; i < 2; i++ {
+		ctrl, _ := ParseDebianControl(strings.NewReader(testTranslationControl), nil)
+		para, _ := translateDescription(*ctrl.Data[0], false)
+		trans.add(para)
+	}
+
+	lines := strings.Split(buf.String(), "\n")
+	if !strings.HasPrefix(lines[0], "Package: test") ||
+		!strings.HasPrefix(lines[1], "Description-md5: ") ||
+		!strings.HasPrefix(lines[2], "Description-en: a test package") {
+		t.Errorf("unexpected translation paragraph, %q", buf.String())
+	}
+
+	if strings.Count(buf.String(), "Package: test") != 1 {
+		t.Errorf("duplicate translation written, %q", buf.String())
+	}
+}
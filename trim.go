@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -12,21 +14,76 @@ import (
 type Trimmer func(*Release) bool
 
 // MakeTimeTrimmer creates a trimmer function that reduces the repository
-// history to a given window of time
-func MakeTimeTrimmer(time.Duration) Trimmer {
+// history to a given window of time. The most recent commit is always
+// kept, so that the window is enforced as soon as it is exceeded
+func MakeTimeTrimmer(window time.Duration) Trimmer {
+	cutoff := time.Now().Add(-window)
+	first := true
 	return func(commit *Release) (trim bool) {
-		return false
+		if first {
+			first = false
+			return false
+		}
+		return commit.Date.Before(cutoff)
+	}
+}
+
+// MakeCombinedTrimmer creates a trimmer function that will trim the history
+// when any of the passed trimmers would
+func MakeCombinedTrimmer(trimmers ...Trimmer) Trimmer {
+	return func(commit *Release) (trim bool) {
+		// Every trimmer is called, as they may be tracking state
+		for _, t := range trimmers {
+			if t(commit) {
+				trim = true
+			}
+		}
+		return
+	}
+}
+
+// ParseAge parses the age of items to retain. As well as the units
+// understood by time.ParseDuration, a number of days (d) or weeks (w)
+// can be given, e.g. "90d". Ages must be positive.
+func ParseAge(str string) (time.Duration, error) {
+	var age time.Duration
+	switch {
+	case strings.HasSuffix(str, "d"), strings.HasSuffix(str, "w"):
+		unit := 24 * time.Hour
+		if strings.HasSuffix(str, "w") {
+			unit = 7 * 24 * time.Hour
+		}
+		n, err := strconv.ParseUint(str[:len(str)-1], 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid age \"%s\"", str)
+		}
+		age = time.Duration(n) * unit
+	default:
+		var err error
+		age, err = time.ParseDuration(str)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if age <= 0 {
+		return 0, fmt.Errorf("age \"%s\" must be positive", str)
 	}
+
+	return age, nil
 }
 
 // MakeLengthTrimmer creates a trimmer function that reduces the repository
-// history to a given number of commits
+// history to a given number of commits. Commits that only refreshed the
+// release files are not counted
 func MakeLengthTrimmer(commitcount int) Trimmer {
 	log.Println("Trim requested")
 	count := commitcount
 	return func(commit *Release) (trim bool) {
 		if count >= 0 {
-			count--
+			if !commit.IsRefresh() {
+				count--
+			}
 			return false
 		}
 		return true
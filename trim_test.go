@@ -0,0 +1,133 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+var testTrimAges = []struct {
+	str    string
+	result time.Duration
+	err    bool
+}{
+	{"90d", 90 * 24 * time.Hour, false},
+	{"2w", 14 * 24 * time.Hour, false},
+	{"36h", 36 * time.Hour, false},
+	{"d", 0, true},
+	{"-1d", 0, true},
+	{"0d", 0, true},
+	{"-36h", 0, true},
+	{"0s", 0, true},
+	{"ninety", 0, true},
+}
+
+func TestParseAge(t *testing.T) {
+	for _, tt := range testTrimAges {
+		d, err := ParseAge(tt.str)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseAge(%v) should have failed", tt.str)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseAge(%v) failed, %v", tt.str, err)
+			continue
+		}
+		if d != tt.result {
+			t.Errorf("ParseAge(%v) = %v, expected %v", tt.str, d, tt.result)
+		}
+	}
+}
+
+func TestCombinedTrimmer(t *testing.T) {
+	now := time.Now()
+	history := []*Release{
+		{Date: now.Add(-1 * time.Hour)},
+		{Date: now.Add(-2 * time.Hour)},
+		{Date: now.Add(-3 * time.Hour)},
+		{Date: now.Add(-48 * time.Hour)},
+		{Date: now.Add(-72 * time.Hour)},
+	}
+
+	trimPoint := func(trimmer Trimmer) int {
+		for i, r := range history {
+			if trimmer(r) {
+				return i
+			}
+		}
+		return -1
+	}
+
+	if i := trimPoint(MakeTimeTrimmer(24 * time.Hour)); i != 3 {
+		t.Errorf("time trimmer trimmed at %v, expected 3", i)
+	}
+
+	if i := trimPoint(MakeCombinedTrimmer(MakeLengthTrimmer(1), MakeTimeTrimmer(24*time.Hour))); i != 2 {
+		t.Errorf("combined trimmer trimmed at %v, expected 2", i)
+	}
+
+	if i := trimPoint(MakeCombinedTrimmer(MakeLengthTrimmer(10), MakeTimeTrimmer(24*time.Hour))); i != 3 {
+		t.Errorf("combined trimmer trimmed at %v, expected 3", i)
+	}
+
+	old := []*Release{
+		{Date: now.Add(-48 * time.Hour)},
+		{Date: now.Add(-72 * time.Hour)},
+	}
+	if trimmer := MakeTimeTrimmer(24 * time.Hour); trimmer(old[0]) || !trimmer(old[1]) {
+		t.Errorf("time trimmer should keep only the most recent release")
+	}
+
+	if i := trimPoint(MakeCombinedTrimmer()); i != -1 {
+		t.Errorf("empty trimmer trimmed at %v, expected no trim", i)
+	}
+}
+
+func TestTrimHistoryByAge(t *testing.T) {
+	a, clean, err := makeTestArchive(t)
+	if err != nil {
+		return
+	}
+	defer clean()
+
+	relid := makeTestRelease(t, a, "test", nil)
+	for _, age := range []time.Duration{72 * time.Hour, 48 * time.Hour, 30 * time.Hour} {
+		rel, _ := a.GetRelease(relid)
+		rel = rel.NewChild()
+		rel.Date = time.Now().Add(-age)
+		relid, _ = a.AddRelease(rel)
+	}
+	parent, _ := a.GetRelease(relid)
+
+	// Every release is older than the window, the parent is still kept
+	child := parent.NewChild()
+	if err = child.TrimHistory(a, MakeTimeTrimmer(24*time.Hour)); err != nil {
+		t.Fatalf("trimming history failed, %v", err)
+	}
+	if child.TrimAfter != 1 {
+		t.Errorf("expected history to be trimmed after 1 release, got %v", child.TrimAfter)
+	}
+	if len(child.Actions) != 1 || child.Actions[0].Type != ActionTRIM {
+		t.Errorf("expected the trim to be logged, got %v", child.Actions)
+	}
+
+	// A length of 0 places no limit when an age is given
+	cfg := ReleaseConfig{AutoTrimLength: 0, AutoTrimAge: "60h"}
+	child = parent.NewChild()
+	if err = child.TrimHistory(a, cfg.MakeTrimmer()); err != nil {
+		t.Fatalf("trimming history failed, %v", err)
+	}
+	if child.TrimAfter != 2 {
+		t.Errorf("expected history to be trimmed after 2 releases, got %v", child.TrimAfter)
+	}
+
+	cfg = ReleaseConfig{AutoTrimLength: 0}
+	child = parent.NewChild()
+	if err = child.TrimHistory(a, cfg.MakeTrimmer()); err != nil {
+		t.Fatalf("trimming history failed, %v", err)
+	}
+	if child.TrimAfter != 1 {
+		t.Errorf("expected history to be trimmed after 1 release, got %v", child.TrimAfter)
+	}
+}
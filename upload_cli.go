@@ -8,6 +8,7 @@ import (
 	"log"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -35,11 +36,12 @@ type failResponse struct {
 // CmdUpload is the implementation of the godinstall "upload" command
 func CmdUpload(c *cli.Context) {
 	ret := 0
-	url := c.String("url")
+	uri := c.String("url")
+	component := c.String("component")
 	client := &http.Client{}
 
 	for _, a := range c.Args() {
-		err := cliUploadFile(client, url, a)
+		err := cliUploadFile(client, uri, a, component)
 
 		if err != nil {
 			log.Printf("Upload of %s failed, %s", a, err.Error())
@@ -100,7 +102,7 @@ func newfileUploadRequest(uri string, paramName, path string) (*http.Request, er
 	return resp.req, resp.err
 }
 
-func cliUploadFile(c *http.Client, uri, firstfn string) error {
+func cliUploadFile(c *http.Client, uri, firstfn, component string) error {
 	dir := filepath.Dir(firstfn)
 	switch {
 	case strings.HasSuffix(firstfn, ".deb"), strings.HasSuffix(firstfn, ".changes"):
@@ -117,7 +119,13 @@ func cliUploadFile(c *http.Client, uri, firstfn string) error {
 
 			log.Printf("Uploading %s\n", fn)
 
-			req, err := newfileUploadRequest(uri, "debfiles", dir+"/"+fn)
+			// The component is only needed when creating the session
+			reqURI := uri
+			if sessionid == "" && component != "" {
+				reqURI = uri + "?component=" + url.QueryEscape(component)
+			}
+
+			req, err := newfileUploadRequest(reqURI, "debfiles", dir+"/"+fn)
 			if err != nil {
 				return err
 			}
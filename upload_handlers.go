@@ -76,7 +76,12 @@ func httpUploadHandler(ctx context.Context, w http.ResponseWriter, r *http.Reque
 					loneDeb = true
 				}
 
-				session, err = state.SessionManager.NewSession(rel, changesReader, loneDeb)
+				component := r.FormValue("component")
+				if component != "" && !rel.Config().AllowedComponent(component) {
+					return sendResponse(w, http.StatusBadRequest, "Component "+component+" is not allowed in this distribution")
+				}
+
+				session, err = state.SessionManager.NewSession(rel, changesReader, loneDeb, component)
 				if err != nil {
 					return &appError{Error: fmt.Errorf("failed creating session, %v", err)}
 				}
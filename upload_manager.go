@@ -74,7 +74,7 @@ func (usm *UploadSessionManager) GetSession(sid string) (s UploadSession, ok boo
 
 // NewSession adds a new upload session based on the details from the passed
 // debian changes file.
-func (usm *UploadSessionManager) NewSession(rel *Release, changesReader io.ReadCloser, loneDeb bool) (string, error) {
+func (usm *UploadSessionManager) NewSession(rel *Release, changesReader io.ReadCloser, loneDeb bool, component string) (string, error) {
 	var err error
 
 	ctx, _ := context.WithTimeout(context.Background(), usm.TTL)
@@ -82,6 +82,7 @@ func (usm *UploadSessionManager) NewSession(rel *Release, changesReader io.ReadC
 		ctx,
 		rel,
 		loneDeb,
+		component,
 		changesReader,
 		usm.TmpDir,
 		usm,
@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"os"
@@ -26,10 +27,14 @@ type UploadFile struct {
 	SignedBy         []string   `json:",omitempty"`
 	UploadHookResult HookOutput `json:",omitempty"`
 
-	pkg       DebPackageInfoer
-	reader    io.Reader
-	storeID   StoreID
-	controlID StoreID
+	pkg        DebPackageInfoer
+	reader     io.Reader
+	storeID    StoreID
+	controlID  StoreID
+	contentsID StoreID
+	md5        []byte
+	sha1       []byte
+	sha256     []byte
 }
 
 // UploadSession holds the information relating to an active upload session
@@ -38,6 +43,7 @@ type UploadSession struct {
 	ReleaseName       string                 // The release this is meant for
 	Expecting         map[string]*UploadFile // The files we are expecting in this upload
 	LoneDeb           bool                   // Is user attempting to upload a lone deb
+	Component         string                 `json:",omitempty"` // Component requested for the upload, overrides the Section
 	Complete          bool                   // The files we are expecting in this upload
 	PreGenHookOutput  *HookOutput            `json:",omitempty"`
 	PostGenHookOutput *HookOutput            `json:",omitempty"`
@@ -76,6 +82,7 @@ func NewUploadSession(
 	ctx context.Context,
 	rel *Release,
 	loneDeb bool,
+	component string,
 	changesReader io.ReadCloser,
 	tmpDirBase *string,
 	uploadSessionManager *UploadSessionManager,
@@ -88,6 +95,11 @@ func NewUploadSession(
 	s.dir = *tmpDirBase + "/" + s.SessionID
 	s.Expecting = make(map[string]*UploadFile, 0)
 	s.LoneDeb = loneDeb
+	s.Component = component
+
+	if component != "" && !rel.Config().AllowedComponent(component) {
+		return UploadSession{}, fmt.Errorf("component %v is not allowed in this distribution", component)
+	}
 
 	os.Mkdir(s.dir, os.FileMode(0755))
 
@@ -124,8 +136,23 @@ func NewUploadSession(
 			return UploadSession{}, err
 		}
 
+		for _, arch := range changes.Architectures {
+			if err := s.checkArchitecture(arch); err != nil {
+				return UploadSession{}, err
+			}
+		}
+
+		for _, arch := range changes.Architectures {
+			if arch == "source" && changes.SourceDsc() == "" {
+				return UploadSession{}, errors.New("Source upload is incomplete, no dsc file listed in changes")
+			}
+		}
+
 		s.Expecting = map[string]*UploadFile{}
 		for k := range changes.FileHashes {
+			if _, err := s.fileComponent(k.Name); err != nil {
+				return UploadSession{}, err
+			}
 			s.Expecting[k.Name] = &UploadFile{Name: k.Name}
 		}
 	}
@@ -144,6 +171,31 @@ type getStatusMsg struct {
 	resp chan UploadSession
 }
 
+// fileComponent returns the component that a file from the upload should
+// be added to. This is the component requested for the upload, or the
+// one implied by the section of the file in the changes file.
+func (s *UploadSession) fileComponent(name string) (string, error) {
+	comp := s.Component
+	if comp == "" {
+		comp = SectionComponent(s.changes.FileSection(name))
+	}
+
+	if !s.release.Config().AllowedComponent(comp) {
+		return "", fmt.Errorf("component %v for %v is not allowed in this distribution", comp, name)
+	}
+
+	return comp, nil
+}
+
+// checkArchitecture returns an error if packages for the architecture
+// may not be added to the distribution
+func (s *UploadSession) checkArchitecture(arch string) error {
+	if !s.release.Config().AllowedArchitecture(arch) {
+		return fmt.Errorf("architecture %v is not allowed in this distribution", arch)
+	}
+	return nil
+}
+
 // All item additions to this session are
 // serialized through this routine
 func (s *UploadSession) handler(ctx context.Context) {
@@ -293,6 +345,10 @@ func (s *UploadSession) doAddFile(upload *UploadFile) (err error) {
 				if err != nil {
 					return errors.New("Generating changes file failed,  " + err.Error())
 				}
+				_, err = s.fileComponent(upload.Name)
+				if err != nil {
+					return err
+				}
 				changesWriter, err := s.usm.Store.Store()
 				if err != nil {
 					return errors.New("Generating changes file failed,  " + err.Error())
@@ -317,6 +373,13 @@ func (s *UploadSession) doAddFile(upload *UploadFile) (err error) {
 				return errors.New("Retrieving control file failed, " + err.Error())
 			}
 
+			if archs, ok := ctrl.Data[0].GetValues("Architecture"); ok {
+				err = s.checkArchitecture(*archs[0])
+				if err != nil {
+					return err
+				}
+			}
+
 			ctrl.Data[0].SetValue("Size", strconv.FormatInt(size, 10))
 			ctrl.Data[0].SetValue("MD5sum", hex.EncodeToString(md5))
 			ctrl.Data[0].SetValue("SHA1", hex.EncodeToString(sha1))
@@ -327,6 +390,15 @@ func (s *UploadSession) doAddFile(upload *UploadFile) (err error) {
 				return errors.New("Storing control file failed, " + err.Error())
 			}
 
+			// Store the file list for the Contents indexes
+			files, _ := uf.pkg.Files()
+			if len(files) != 0 {
+				uf.contentsID, err = s.usm.Store.AddContents(files)
+				if err != nil {
+					return errors.New("Storing contents failed, " + err.Error())
+				}
+			}
+
 			// We should verify the signature
 			if s.release.Config().VerifyDebs &&
 				(!s.release.Config().VerifyChangesSufficient || s.LoneDeb) {
@@ -346,6 +418,9 @@ func (s *UploadSession) doAddFile(upload *UploadFile) (err error) {
 		}
 	case strings.HasSuffix(upload.Name, ".dsc"):
 		{
+			if s.LoneDeb {
+				return errors.New("Source packages must be uploaded with a changes file")
+			}
 			f, _ := s.usm.Store.Open(id)
 			defer f.Close()
 			kr, err := s.release.PubRing()
@@ -356,6 +431,26 @@ func (s *UploadSession) doAddFile(upload *UploadFile) (err error) {
 			if err != nil {
 				return errors.New("Parsing dsc failed, " + err.Error())
 			}
+
+			// We should verify the signature
+			if s.release.Config().VerifyDscs &&
+				!(s.release.Config().VerifyChangesSufficient && s.changes.Control.SignatureVerified) {
+				if !ctrl.Signed {
+					return errors.New("Dsc file was not signed")
+				}
+				if !ctrl.SignatureVerified {
+					return errors.New("Dsc file could not be verified")
+				}
+			}
+			if ctrl.SignatureVerified {
+				uf.SignedBy = ctrl.SignedBy
+			}
+
+			err = s.changes.VerifyDsc(ctrl)
+			if err != nil {
+				return err
+			}
+
 			uf.controlID, err = s.usm.Store.AddControlFile(ctrl)
 			if err != nil {
 				return errors.New("Storing control file failed, " + err.Error())
@@ -363,6 +458,11 @@ func (s *UploadSession) doAddFile(upload *UploadFile) (err error) {
 		}
 	}
 
+	if !s.LoneDeb && expectedFileIdx.Size != size {
+		err = errors.New("Uploaded file size does not match")
+		return err
+	}
+
 	if !s.LoneDeb && (bytes.Compare(s.changes.FileHashes[expectedFileIdx]["md5"], md5) != 0 ||
 		bytes.Compare(s.changes.FileHashes[expectedFileIdx]["sha1"], sha1) != 0 ||
 		bytes.Compare(s.changes.FileHashes[expectedFileIdx]["sha256"], sha256) != 0) {
@@ -377,6 +477,9 @@ func (s *UploadSession) doAddFile(upload *UploadFile) (err error) {
 
 	uf.storeID = id
 	uf.Size = size
+	uf.md5 = md5
+	uf.sha1 = sha1
+	uf.sha256 = sha256
 	uf.UploadHookResult = s.usm.UploadHook.Run(storeFilename)
 	if uf.UploadHookResult.err != nil {
 		os.Remove(storeFilename)
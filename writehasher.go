@@ -4,6 +4,7 @@ import (
 	"crypto/md5"
 	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/sha512"
 	"hash"
 	"io"
 )
@@ -16,6 +17,7 @@ type WriteHasher struct {
 	md5er    hash.Hash
 	sha1er   hash.Hash
 	sha256er hash.Hash
+	sha512er hash.Hash
 }
 
 // Write writes some bytes to the hasher and the backing
@@ -25,6 +27,7 @@ func (w *WriteHasher) Write(p []byte) (n int, err error) {
 	w.md5er.Write(p)
 	w.sha1er.Write(p)
 	w.sha256er.Write(p)
+	w.sha512er.Write(p)
 	w.count += int64(n)
 	return
 }
@@ -50,7 +53,12 @@ func (w *WriteHasher) SHA256Sum() []byte {
 	return w.sha256er.Sum(nil)
 }
 
-// MakeWriteHasher creates an io.Writer to calculate the sha1,sha256 and
+// SHA512Sum of the input so far
+func (w *WriteHasher) SHA512Sum() []byte {
+	return w.sha512er.Sum(nil)
+}
+
+// MakeWriteHasher creates an io.Writer to calculate the sha1, sha256, sha512 and
 // md5 sums and measure the size of a data written to the passed writer
 func MakeWriteHasher(w io.Writer) *WriteHasher {
 	return &WriteHasher{
@@ -59,5 +67,6 @@ func MakeWriteHasher(w io.Writer) *WriteHasher {
 		md5er:    md5.New(),
 		sha1er:   sha1.New(),
 		sha256er: sha256.New(),
+		sha512er: sha512.New(),
 	}
 }